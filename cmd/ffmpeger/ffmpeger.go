@@ -17,21 +17,36 @@ import (
 func main() {
 	log.Println("Starting video conversion service")
 
-	config.Initialize()
-	nats.Initialize()
-	converter.Initialize()
-
+	var (
+		configPath             string
+		maximumConcurrentTasks int
+	)
+	flag.StringVar(&configPath, "conf", "", "Path to configuration file.")
+	flag.IntVar(&maximumConcurrentTasks, "maxconcurrency", 1, "Maximum conversion tasks that should be run concurrently")
 	flag.Parse()
 
-	err := config.Load()
+	cfg, err := config.NewLoader(configPath).Load()
 	if err != nil {
 		log.Fatalln("Failed to load configuration file:", err.Error())
 	}
-	err1 := nats.StartListening()
+
+	client, err1 := nats.NewClient(&cfg.NATS)
 	if err1 != nil {
-		log.Fatalln("Failed to establish connection to NATS:", err1.Error())
+		log.Fatalln("Failed to initialize NATS client:", err1.Error())
+	}
+	conv, err2 := converter.New(cfg, client, converter.WithMaxConcurrency(maximumConcurrentTasks))
+	if err2 != nil {
+		log.Fatalln("Failed to initialize converter:", err2.Error())
+	}
+
+	err3 := client.StartListening()
+	if err3 != nil {
+		log.Fatalln("Failed to establish connection to NATS:", err3.Error())
+	}
+	err4 := conv.Start()
+	if err4 != nil {
+		log.Fatalln("Failed to start converter:", err4.Error())
 	}
-	converter.Start()
 
 	// CTRL+C handler.
 	signalHandler := make(chan os.Signal, 1)
@@ -39,8 +54,14 @@ func main() {
 	signal.Notify(signalHandler, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-signalHandler
-		nats.Shutdown()
-		converter.Shutdown()
+		// Converter stops receiving new tasks itself but keeps handling
+		// control requests while draining, connection is needed until
+		// it's done to publish results and re-queued tasks.
+		conv.Shutdown()
+		err5 := client.Shutdown()
+		if err5 != nil {
+			log.Println("ERROR: failed to shutdown NATS connection:", err5.Error())
+		}
 		shutdownDone <- true
 	}()
 
@@ -6,6 +6,8 @@ import (
 	"flag"
 	"log"
 	"path/filepath"
+	"strconv"
+	"time"
 
 	// local
 	"github.com/pztrn/ffmpeger/config"
@@ -17,21 +19,43 @@ import (
 )
 
 var (
+	configPath     string
 	inputFilename  string
 	outputFilename string
+	profileName    string
+	priority       int
+	waitForResult  bool
+	waitTimeout    time.Duration
+	replyTimeout   time.Duration
+	probeOnly      bool
+	cancelTaskID   string
+
+	cfg      *config.Config
+	subjects *mynats.Subjects
 )
 
 func main() {
 	log.Println("Starting example message sender...")
 
+	flag.StringVar(&configPath, "conf", "", "Path to configuration file.")
 	flag.StringVar(&inputFilename, "input", "", "Input file name")
 	flag.StringVar(&outputFilename, "output", "", "Output file name")
-
-	config.Initialize()
+	flag.StringVar(&profileName, "profile", "", "Encoding profile name (default profile will be used if empty)")
+	flag.IntVar(&priority, "priority", 0, "Task priority, tasks with higher priority are launched first")
+	flag.BoolVar(&waitForResult, "wait", false, "Wait for task result and print it")
+	flag.DurationVar(&waitTimeout, "timeout", time.Hour, "How long to wait for task result")
+	flag.DurationVar(&replyTimeout, "replytimeout", time.Second*5, "How long to wait for task acknowledgement")
+	flag.BoolVar(&probeOnly, "probe", false, "Only probe input file and print it's media information")
+	flag.StringVar(&cancelTaskID, "cancel", "", "Cancel task with passed ID instead of submitting new one")
 
 	flag.Parse()
 
-	if inputFilename == "" || outputFilename == "" {
+	if cancelTaskID != "" {
+		cancel()
+		return
+	}
+
+	if inputFilename == "" || (outputFilename == "" && !probeOnly) {
 		log.Fatalln("Please specify both input and output file name!")
 	}
 
@@ -40,36 +64,241 @@ func main() {
 	if err != nil {
 		log.Fatalln("Failed to get absolute path for input filename:", err.Error())
 	}
-	outputFilename, err = filepath.Abs(outputFilename)
+	if !probeOnly {
+		outputFilename, err = filepath.Abs(outputFilename)
+		if err != nil {
+			log.Fatalln("Failed to get absolute path for output filename:", err.Error())
+		}
+	}
+
+	loadConfig()
+	nc := connect()
+
+	if probeOnly {
+		probe(nc)
+		nc.Close()
+		return
+	}
+
+	t := &converter.Task{
+		InputFile:  inputFilename,
+		OutputFile: outputFilename,
+		Profile:    profileName,
+		Priority:   priority,
+	}
+
+	data, err1 := json.Marshal(t)
+	if err1 != nil {
+		log.Fatalln("Failed to encode message:", err1.Error())
+	}
+
+	// Subscribe to results before publishing task, otherwise we might
+	// miss result of very fast conversion.
+	var results chan *nats.Msg
+	if waitForResult {
+		results = make(chan *nats.Msg, 64)
+		sub, err2 := nc.ChanSubscribe(subjects.Results, results)
+		if err2 != nil {
+			log.Fatalln("Failed to subscribe to results topic:", err2.Error())
+		}
+		defer sub.Unsubscribe()
+	}
+
+	reply, err3 := nc.Request(subjects.Submit, data, replyTimeout)
+	if err3 != nil {
+		log.Fatalln("Failed to submit task:", err3.Error())
+	}
+
+	var taskID string
+	if cfg.NATS.JetStream.Enabled {
+		taskID = decodePubAck(reply)
+	} else {
+		taskID = decodeAcknowledgement(reply)
+	}
+
+	if waitForResult {
+		waitForTaskResult(nc, taskID, results)
+	}
+
+	nc.Close()
+}
+
+// Decodes acknowledgement sent by ffmpeger and returns task ID.
+func decodeAcknowledgement(reply *nats.Msg) string {
+	ack := &converter.Acknowledgement{}
+	err := json.Unmarshal(reply.Data, ack)
+	if err != nil {
+		log.Fatalln("Failed to decode task acknowledgement:", err.Error())
+	}
+
+	if ack.Status != converter.AckAccepted {
+		log.Fatalln("Task was rejected:", ack.Reason)
+	}
+
+	log.Println("Task accepted with ID", ack.TaskID, "at queue position", ack.QueuePosition)
+
+	return ack.TaskID
+}
+
+// Decodes acknowledgement sent by JetStream and returns task ID, which
+// is a stream sequence. Task will be validated only when ffmpeger will
+// fetch it.
+func decodePubAck(reply *nats.Msg) string {
+	ack := &mynats.PubAck{}
+	err := json.Unmarshal(reply.Data, ack)
 	if err != nil {
-		log.Fatalln("Failed to get absolute path for output filename:", err.Error())
+		log.Fatalln("Failed to decode JetStream acknowledgement:", err.Error())
 	}
 
-	err = config.Load()
+	if ack.Error != nil {
+		log.Fatalln("Task wasn't stored by JetStream:", ack.Error.Error())
+	}
+
+	taskID := strconv.FormatUint(ack.Sequence, 10)
+	log.Println("Task stored in JetStream stream", ack.Stream, "with ID", taskID)
+
+	return taskID
+}
+
+// Loads configuration file and derives subjects from it.
+func loadConfig() {
+	var err error
+	cfg, err = config.NewLoader(configPath).Load()
 	if err != nil {
 		log.Fatalln("Failed to load configuration file:", err.Error())
 	}
 
-	nc, err := nats.Connect(config.Cfg.NATS.ConnectionString)
+	subjects = mynats.NewSubjects(cfg.NATS.SubjectPrefix)
+}
+
+// Connects to NATS with options from configuration.
+func connect() *nats.Conn {
+	options, err := mynats.ConnectionOptions(&cfg.NATS)
+	if err != nil {
+		log.Fatalln("Failed to prepare NATS connection options:", err.Error())
+	}
+
+	nc, err1 := nats.Connect(cfg.NATS.ConnectionString, options...)
+	if err1 != nil {
+		log.Fatalln("Failed to connect to NATS server:", err1.Error())
+	}
+
+	return nc
+}
+
+// Sends cancellation command for task.
+func cancel() {
+	loadConfig()
+	nc := connect()
+	defer nc.Close()
+
+	data, err := json.Marshal(&converter.ControlCommand{
+		Command: converter.CommandCancel,
+		TaskID:  cancelTaskID,
+	})
 	if err != nil {
-		log.Fatalln("Failed to connect to NATS server:", err.Error())
+		log.Fatalln("Failed to encode command:", err.Error())
 	}
 
+	reply, err1 := nc.Request(subjects.Control, data, replyTimeout)
+	if err1 != nil {
+		log.Fatalln("Failed to send cancellation command:", err1.Error())
+	}
+
+	controlReply := &converter.ControlReply{}
+	err2 := json.Unmarshal(reply.Data, controlReply)
+	if err2 != nil {
+		log.Fatalln("Failed to decode command reply:", err2.Error())
+	}
+
+	if !controlReply.Success {
+		log.Fatalln("Failed to cancel task:", controlReply.Error)
+	}
+
+	log.Println("Task", cancelTaskID, "cancelled")
+}
+
+// Sends "probe only" task and prints received media information.
+func probe(nc *nats.Conn) {
 	t := &converter.Task{
-		InputFile:  inputFilename,
-		OutputFile: outputFilename,
+		Type:      converter.TaskTypeProbe,
+		InputFile: inputFilename,
 	}
 
-	data, err1 := json.Marshal(t)
+	data, err := json.Marshal(t)
+	if err != nil {
+		log.Fatalln("Failed to encode message:", err.Error())
+	}
+
+	reply, err1 := nc.Request(subjects.Probe, data, replyTimeout)
 	if err1 != nil {
-		log.Fatalln("Failed to encode message:", err1.Error())
+		log.Fatalln("Failed to send probe request:", err1.Error())
 	}
 
-	err2 := nc.Publish(mynats.Topic, data)
+	probeReply := &converter.ProbeReply{}
+	err2 := json.Unmarshal(reply.Data, probeReply)
 	if err2 != nil {
-		log.Fatalln("Failed to publish message:", err2.Error())
+		log.Fatalln("Failed to decode probe reply:", err2.Error())
 	}
 
-	log.Println("Message published")
-	nc.Close()
+	if probeReply.MediaInfo == nil {
+		log.Fatalln("Failed to probe input file:", probeReply.Error)
+	}
+
+	log.Printf("Media information: %+v\n", probeReply.MediaInfo)
+}
+
+// Waits for result of task with passed ID and prints it.
+func waitForTaskResult(nc *nats.Conn, taskID string, results chan *nats.Msg) {
+	log.Println("Waiting for task result...")
+
+	progress := make(chan *nats.Msg, 64)
+	sub, err := nc.ChanSubscribe(subjects.Progress(taskID), progress)
+	if err != nil {
+		log.Fatalln("Failed to subscribe to progress topic:", err.Error())
+	}
+	defer sub.Unsubscribe()
+
+	timeout := time.After(waitTimeout)
+	for {
+		select {
+		case <-timeout:
+			log.Fatalln("Timed out waiting for task result")
+		case msg := <-progress:
+			p := &converter.Progress{}
+			err := json.Unmarshal(msg.Data, p)
+			if err != nil {
+				log.Println("Failed to decode task progress:", err.Error())
+				continue
+			}
+
+			log.Printf("Progress: %.1f%% done, frame %d, %.2fx speed, ETA %.0fs\n", p.Percent, p.Frame, p.Speed, p.ETA)
+		case msg := <-results:
+			result := &converter.Result{}
+			err := json.Unmarshal(msg.Data, result)
+			if err != nil {
+				log.Println("Failed to decode task result:", err.Error())
+				continue
+			}
+
+			if result.TaskID != taskID {
+				continue
+			}
+
+			if result.Status == converter.StatusRetrying {
+				log.Printf("Attempt %d failed, task will be retried: %+v\n", result.Attempt, result.Error)
+				continue
+			}
+
+			// Another instance took task on shutdown.
+			if result.Status == converter.StatusRequeued && result.RequeuedAs != "" {
+				log.Println("Task was re-queued as", result.RequeuedAs)
+				waitForTaskResult(nc, result.RequeuedAs, results)
+				return
+			}
+
+			log.Printf("Task finished: %+v\n", result)
+			return
+		}
+	}
 }
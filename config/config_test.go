@@ -2,12 +2,12 @@ package config
 
 import (
 	// stdlib
-	"flag"
 	"io/ioutil"
 	"os"
 	"os/user"
 	"strings"
 	"testing"
+	"time"
 
 	// other
 	"github.com/stretchr/testify/require"
@@ -18,66 +18,92 @@ const (
   connection_string: "nats://127.0.0.1:14222"`
 	testConfigBad = `nats
 connection_string: "nats://127.0.0.1:14222"`
-	testConfigPath = "/tmp/ffmpeger-test-config"
+	testConfigPath        = "/tmp/ffmpeger-test-config"
+	testConfigWithProfile = `nats:
+  connection_string: "nats://127.0.0.1:14222"
+profiles:
+  webm:
+    video_codec: "libvpx-vp9"
+    crf: 31
+    audio_codec: "libopus"
+    audio_bitrate: "96k"
+    container: "webm"`
+	testConfigWithBadProfile = `nats:
+  connection_string: "nats://127.0.0.1:14222"
+profiles:
+  broken:
+    video_bitrate: "1000k"
+    crf: 23`
 )
 
-func TestConfigPackageInitialization(t *testing.T) {
-	Initialize()
-	require.Empty(t, configPathRaw)
-}
-
 func TestConfigFileLoad(t *testing.T) {
-	flag.CommandLine = flag.NewFlagSet("ffmpeger-test-config-load", flag.ExitOnError)
-	Initialize()
-
 	err := ioutil.WriteFile(testConfigPath, []byte(testConfig), os.ModePerm)
 	if err != nil {
 		t.Fatal("Failed to write test config file:", err.Error())
 	}
 
-	configPathRaw = testConfigPath
-	err1 := Load()
+	cfg, err1 := NewLoader(testConfigPath).Load()
 	require.Nil(t, err1)
-	require.NotEmpty(t, Cfg.NATS.ConnectionString)
-	require.Equal(t, "nats://127.0.0.1:14222", Cfg.NATS.ConnectionString)
+	require.NotEmpty(t, cfg.NATS.ConnectionString)
+	require.Equal(t, "nats://127.0.0.1:14222", cfg.NATS.ConnectionString)
+
+	// Defaults.
+	require.Equal(t, "drain", cfg.Shutdown.Mode)
+	require.Equal(t, time.Minute*5, cfg.Shutdown.DrainTimeout)
+	require.Equal(t, "store", cfg.Shutdown.Requeue)
+	require.Equal(t, 3, cfg.Retry.MaxAttempts)
+	require.Equal(t, time.Second*30, cfg.Retry.BackoffBase)
+	require.Equal(t, time.Minute*30, cfg.Retry.BackoffCap)
+	require.Equal(t, []string{"input", "encode"}, cfg.Retry.RetryableErrors)
+	require.Equal(t, time.Duration(0), cfg.Timeout.Conversion)
+	require.Equal(t, time.Minute*5, cfg.Timeout.Stall)
+	require.False(t, cfg.Verify.Enabled)
+	require.Equal(t, time.Second, cfg.Verify.DurationTolerance)
+}
+
+func TestConfigValidate(t *testing.T) {
+	// Configuration composed in code gets same defaults.
+	cfg := &Config{}
+	require.Nil(t, cfg.Validate())
+	require.Equal(t, "ffmpeger.v1", cfg.NATS.SubjectPrefix)
+	require.Equal(t, time.Minute, cfg.NATS.JetStream.AckWait)
+	require.Equal(t, "drain", cfg.Shutdown.Mode)
+	require.Equal(t, time.Minute*5, cfg.Timeout.Stall)
+	require.Equal(t, time.Second, cfg.Verify.DurationTolerance)
+
+	// Validating twice changes nothing.
+	validated := *cfg
+	require.Nil(t, cfg.Validate())
+	require.Equal(t, validated, *cfg)
+
+	cfg.Timeout.Stall = -time.Second
+	require.NotNil(t, cfg.Validate())
 }
 
 func TestConfigFileLoadWithoutFilePath(t *testing.T) {
-	flag.CommandLine = flag.NewFlagSet("ffmpeger-test-config-load", flag.ExitOnError)
-	Initialize()
-	err := Load()
+	cfg, err := NewLoader("").Load()
 	require.NotNil(t, err)
-	require.Empty(t, Cfg.NATS.ConnectionString)
+	require.Nil(t, cfg)
 }
 
 func TestConfigFileLoadWithoutConfigItself(t *testing.T) {
-	flag.CommandLine = flag.NewFlagSet("ffmpeger-test-config-load", flag.ExitOnError)
-	Initialize()
-	configPathRaw = "/tmp/nonexistant"
-	err := Load()
+	cfg, err := NewLoader("/tmp/nonexistant").Load()
 	require.NotNil(t, err)
-	require.Empty(t, Cfg.NATS.ConnectionString)
+	require.Nil(t, cfg)
 }
 
 func TestConfigFileLoadBadConfigFile(t *testing.T) {
-	flag.CommandLine = flag.NewFlagSet("ffmpeger-test-config-load", flag.ExitOnError)
-	Initialize()
-
 	err := ioutil.WriteFile(testConfigPath, []byte(testConfigBad), os.ModePerm)
 	if err != nil {
 		t.Fatal("Failed to write test config file:", err.Error())
 	}
 
-	configPathRaw = testConfigPath
-	err1 := Load()
+	cfg, err1 := NewLoader(testConfigPath).Load()
 	require.NotNil(t, err1)
-	require.Empty(t, Cfg.NATS.ConnectionString)
+	require.Nil(t, cfg)
 }
 
 func TestConfigFileLoadWithTilde(t *testing.T) {
-	flag.CommandLine = flag.NewFlagSet("ffmpeger-test-config-load", flag.ExitOnError)
-	Initialize()
-
 	// Sorry, this test isn't supposed to be run on Windows. Patches
 	// welcome, until don't even try to whine :).
 	tildeConfigPath := "~/.cache/ffmpeger-test-config"
@@ -92,8 +118,95 @@ func TestConfigFileLoadWithTilde(t *testing.T) {
 		t.Fatal("Failed to write test config file:", err1.Error())
 	}
 
-	configPathRaw = tildeConfigPath
-	err2 := Load()
+	cfg, err2 := NewLoader(tildeConfigPath).Load()
 	require.NotNil(t, err2)
-	require.Empty(t, Cfg.NATS.ConnectionString)
+	require.Nil(t, cfg)
+}
+
+func TestConfigFileLoadWithProfiles(t *testing.T) {
+	err := ioutil.WriteFile(testConfigPath, []byte(testConfigWithProfile), os.ModePerm)
+	if err != nil {
+		t.Fatal("Failed to write test config file:", err.Error())
+	}
+
+	cfg, err1 := NewLoader(testConfigPath).Load()
+	require.Nil(t, err1)
+	require.Len(t, cfg.Profiles, 1)
+	require.Equal(t, "libvpx-vp9", cfg.Profiles["webm"].VideoCodec)
+	require.Equal(t, 31, cfg.Profiles["webm"].CRF)
+	require.Equal(t, "webm", cfg.Profiles["webm"].Container)
+}
+
+func TestConfigFileLoadWithBadProfile(t *testing.T) {
+	err := ioutil.WriteFile(testConfigPath, []byte(testConfigWithBadProfile), os.ModePerm)
+	if err != nil {
+		t.Fatal("Failed to write test config file:", err.Error())
+	}
+
+	_, err1 := NewLoader(testConfigPath).Load()
+	require.NotNil(t, err1)
+}
+
+func TestConfigFileLoadWithBadRetry(t *testing.T) {
+	for _, retry := range []string{
+		"max_attempts: -1",
+		`backoff_base: "1m"
+  backoff_cap: "30s"`,
+	} {
+		err := ioutil.WriteFile(testConfigPath, []byte(testConfig+"\nretry:\n  "+retry), os.ModePerm)
+		if err != nil {
+			t.Fatal("Failed to write test config file:", err.Error())
+		}
+
+		_, err1 := NewLoader(testConfigPath).Load()
+		require.NotNil(t, err1, retry)
+	}
+}
+
+func TestConfigFileLoadWithDeprecatedNakDelay(t *testing.T) {
+	err := ioutil.WriteFile(testConfigPath, []byte(testConfig+`
+  jetstream:
+    nak_delay: "10s"`), os.ModePerm)
+	if err != nil {
+		t.Fatal("Failed to write test config file:", err.Error())
+	}
+
+	cfg, err1 := NewLoader(testConfigPath).Load()
+	require.Nil(t, err1)
+	require.Equal(t, time.Second*10, cfg.Retry.BackoffBase)
+}
+
+func TestConfigFileLoadWithRequeueToNATSInPullMode(t *testing.T) {
+	err := ioutil.WriteFile(testConfigPath, []byte(`nats:
+  connection_string: "nats://127.0.0.1:14222"
+  dispatcher:
+    subject: "dispatcher"
+shutdown:
+  requeue: "nats"`), os.ModePerm)
+	if err != nil {
+		t.Fatal("Failed to write test config file:", err.Error())
+	}
+
+	_, err1 := NewLoader(testConfigPath).Load()
+	require.NotNil(t, err1)
+}
+
+func TestConfigFileLoadWithNegativeTimeout(t *testing.T) {
+	err := ioutil.WriteFile(testConfigPath, []byte(testConfig+"\ntimeout:\n  stall: \"-1m\""), os.ModePerm)
+	if err != nil {
+		t.Fatal("Failed to write test config file:", err.Error())
+	}
+
+	_, err1 := NewLoader(testConfigPath).Load()
+	require.NotNil(t, err1)
+}
+
+func TestConfigFileLoadWithNegativeDurationTolerance(t *testing.T) {
+	err := ioutil.WriteFile(testConfigPath, []byte(testConfig+"\nverify:\n  enabled: true\n  duration_tolerance: \"-1s\""), os.ModePerm)
+	if err != nil {
+		t.Fatal("Failed to write test config file:", err.Error())
+	}
+
+	_, err1 := NewLoader(testConfigPath).Load()
+	require.NotNil(t, err1)
 }
@@ -3,45 +3,44 @@ package config
 import (
 	// stdlib
 	"errors"
-	"flag"
 	"io/ioutil"
 	"log"
 	"os/user"
 	"path/filepath"
 	"strings"
+	"time"
 
 	// other
 	"gopkg.in/yaml.v2"
 )
 
-var (
-	configPathRaw string
-	Cfg           *Config
-)
-
-// Initialize initializes package.
-func Initialize() {
-	log.Println("Initializing configuration...")
-
-	flag.StringVar(&configPathRaw, "conf", "", "Path to configuration file.")
+// Loader loads configuration from file.
+type Loader struct {
+	path string
+}
 
-	Cfg = &Config{}
+// NewLoader creates loader for configuration file at passed path. "~"
+// in path is replaced with current user's home directory.
+func NewLoader(path string) *Loader {
+	return &Loader{path: path}
 }
 
-// Load loads configuration into memory and parses it into Config struct.
-func Load() error {
-	if configPathRaw == "" {
-		return errors.New("No configuration file path defined! See '-h'!")
+// Load loads configuration file and parses it into Config struct.
+func (l *Loader) Load() (*Config, error) {
+	if l.path == "" {
+		return nil, errors.New("No configuration file path defined! See '-h'!")
 	}
 
-	log.Println("Loading configuration from file:", configPathRaw)
+	log.Println("Loading configuration from file:", l.path)
+
+	configPathRaw := l.path
 
 	// Replace home directory if "~" was specified.
 	if strings.Contains(configPathRaw, "~") {
 		u, err := user.Current()
 		if err != nil {
 			// Well, I don't know how to test this.
-			return errors.New("Failed to get current user's data: " + err.Error())
+			return nil, errors.New("Failed to get current user's data: " + err.Error())
 		}
 
 		configPathRaw = strings.Replace(configPathRaw, "~", u.HomeDir, 1)
@@ -51,21 +50,316 @@ func Load() error {
 	configPath, err := filepath.Abs(configPathRaw)
 	if err != nil {
 		// Can't think of situation when it's testable.
-		return errors.New("Failed to get real configuration file path:" + err.Error())
+		return nil, errors.New("Failed to get real configuration file path:" + err.Error())
 	}
 
 	// Read it.
 	configFileData, err := ioutil.ReadFile(configPath)
 	if err != nil {
-		return errors.New("Failed to load configuration file data:" + err.Error())
+		return nil, errors.New("Failed to load configuration file data:" + err.Error())
 	}
 
 	// Parse it.
-	err1 := yaml.Unmarshal(configFileData, Cfg)
+	cfg := &Config{}
+	err1 := yaml.Unmarshal(configFileData, cfg)
 	if err1 != nil {
-		return errors.New("Failed to parse configuration file:" + err1.Error())
+		return nil, errors.New("Failed to parse configuration file:" + err1.Error())
+	}
+
+	err2 := cfg.Validate()
+	if err2 != nil {
+		return nil, err2
+	}
+
+	log.Printf("Configuration file parsed: %+v\n", cfg)
+	return cfg, nil
+}
+
+// Validate checks configuration and fills defaults. Configuration
+// loaded from file is already validated, configuration composed in code
+// should be validated before use.
+func (c *Config) Validate() error {
+	err := c.NATS.Validate()
+	if err != nil {
+		return errors.New("Invalid NATS configuration: " + err.Error())
+	}
+
+	err1 := c.validateProfiles()
+	if err1 != nil {
+		return errors.New("Invalid encoding profile: " + err1.Error())
+	}
+
+	err2 := c.validateQueue()
+	if err2 != nil {
+		return errors.New("Invalid queue configuration: " + err2.Error())
+	}
+
+	err3 := c.validateShutdown()
+	if err3 != nil {
+		return errors.New("Invalid shutdown configuration: " + err3.Error())
+	}
+
+	err4 := c.validateRetry()
+	if err4 != nil {
+		return errors.New("Invalid retry configuration: " + err4.Error())
+	}
+
+	err5 := c.validateTimeout()
+	if err5 != nil {
+		return errors.New("Invalid timeout configuration: " + err5.Error())
+	}
+
+	err6 := c.validateVerify()
+	if err6 != nil {
+		return errors.New("Invalid verification configuration: " + err6.Error())
+	}
+
+	return nil
+}
+
+// Validate checks NATS configuration and fills defaults.
+func (n *Nats) Validate() error {
+	var authMethods int
+	for _, set := range []bool{
+		n.CredentialsFile != "",
+		n.NKeySeedFile != "",
+		n.User != "" || n.Password != "",
+		n.Token != "",
+	} {
+		if set {
+			authMethods++
+		}
+	}
+
+	if authMethods > 1 {
+		return errors.New("only one of credentials_file, nkey_seed_file, user/password and token can be used")
+	}
+
+	if (n.TLS.CertFile == "") != (n.TLS.KeyFile == "") {
+		return errors.New("both tls cert_file and key_file should be set")
+	}
+
+	if n.ReconnectWait < 0 {
+		return errors.New("reconnect_wait can't be negative")
+	}
+
+	if n.ReconnectWait == 0 {
+		n.ReconnectWait = time.Second * 2
+	}
+
+	// Zero is a valid value but it makes no sense for service.
+	if n.MaxReconnects == 0 {
+		n.MaxReconnects = -1
+	}
+
+	if n.SubjectPrefix == "" {
+		n.SubjectPrefix = "ffmpeger.v1"
+	}
+
+	if strings.ContainsAny(n.SubjectPrefix, "*> ") || strings.HasPrefix(n.SubjectPrefix, ".") || strings.HasSuffix(n.SubjectPrefix, ".") {
+		return errors.New("subject_prefix should be a valid subject without wildcards")
+	}
+
+	js := &n.JetStream
+	if js.Stream == "" {
+		js.Stream = "FFMPEGER"
+	}
+
+	if js.Consumer == "" {
+		js.Consumer = "ffmpeger"
+	}
+
+	// JetStream doesn't allow dots and wildcards in names.
+	for _, name := range []string{js.Stream, js.Consumer} {
+		if strings.ContainsAny(name, ".*> ") {
+			return errors.New("JetStream stream and consumer names can't contain dots, spaces and wildcards")
+		}
+	}
+
+	if js.AckWait < 0 || js.NakDelay < 0 || js.MaxDeliver < 0 {
+		return errors.New("ack_wait, nak_delay and max_deliver can't be negative")
+	}
+
+	if js.AckWait == 0 {
+		js.AckWait = time.Minute
+	}
+
+	if js.MaxDeliver == 0 {
+		js.MaxDeliver = 5
+	}
+
+	dispatcher := &n.Dispatcher
+	if dispatcher.Subject != "" && js.Enabled {
+		return errors.New("dispatcher and JetStream can't be used together")
+	}
+
+	if dispatcher.Wait < 0 {
+		return errors.New("dispatcher wait can't be negative")
+	}
+
+	if dispatcher.Wait == 0 {
+		dispatcher.Wait = time.Second * 30
+	}
+
+	return nil
+}
+
+// Checks that encoding profiles from configuration file are sane.
+func (c *Config) validateProfiles() error {
+	for name, profile := range c.Profiles {
+		if name == "" {
+			return errors.New("profile name can't be empty")
+		}
+
+		if profile.VideoBitrate != "" && profile.CRF != 0 {
+			return errors.New("profile '" + name + "' defines both video_bitrate and crf, only one should be used")
+		}
+
+		if profile.CRF < 0 || profile.CRF > 63 {
+			return errors.New("profile '" + name + "' has CRF value out of 0-63 range")
+		}
+	}
+
+	return nil
+}
+
+// Checks queue configuration and fills defaults.
+func (c *Config) validateQueue() error {
+	switch c.Queue.Store {
+	case "":
+		c.Queue.Store = "memory"
+	case "memory":
+	case "file":
+		if c.Queue.Path == "" {
+			return errors.New("path should be set for file store")
+		}
+	default:
+		return errors.New("unknown store type '" + c.Queue.Store + "'")
+	}
+
+	if c.Queue.KeepFinished < 0 {
+		return errors.New("keep_finished can't be negative")
+	}
+
+	if c.Queue.KeepFinished == 0 {
+		c.Queue.KeepFinished = 100
+	}
+
+	if c.Queue.AgingInterval < 0 {
+		return errors.New("aging_interval can't be negative")
+	}
+
+	if c.Queue.AgingInterval == 0 {
+		c.Queue.AgingInterval = time.Minute
+	}
+
+	if c.Queue.Capacity < 0 {
+		return errors.New("capacity can't be negative")
+	}
+
+	return nil
+}
+
+// Checks shutdown configuration and fills defaults.
+func (c *Config) validateShutdown() error {
+	switch c.Shutdown.Mode {
+	case "":
+		c.Shutdown.Mode = "drain"
+	case "drain", "kill":
+	default:
+		return errors.New("unknown shutdown mode '" + c.Shutdown.Mode + "'")
+	}
+
+	if c.Shutdown.DrainTimeout < 0 {
+		return errors.New("drain_timeout can't be negative")
+	}
+
+	if c.Shutdown.DrainTimeout == 0 {
+		c.Shutdown.DrainTimeout = time.Minute * 5
+	}
+
+	switch c.Shutdown.Requeue {
+	case "":
+		c.Shutdown.Requeue = "store"
+	case "store":
+	case "nats":
+		// Nobody receives tasks from tasks topic in these modes.
+		if c.NATS.Dispatcher.Subject != "" || c.NATS.JetStream.Enabled {
+			return errors.New("requeue to nats can't be used with dispatcher or JetStream")
+		}
+	default:
+		return errors.New("unknown requeue destination '" + c.Shutdown.Requeue + "'")
+	}
+
+	return nil
+}
+
+// Checks retry policy and fills defaults.
+func (c *Config) validateRetry() error {
+	if c.Retry.MaxAttempts < 0 || c.Retry.BackoffBase < 0 || c.Retry.BackoffCap < 0 {
+		return errors.New("max_attempts, backoff_base and backoff_cap can't be negative")
+	}
+
+	// JetStream's redelivery delay was a backoff base before retry
+	// policy was introduced. It's reset, so warning is logged once.
+	if c.NATS.JetStream.NakDelay != 0 {
+		log.Println("WARNING: nats.jetstream.nak_delay is deprecated, use retry.backoff_base instead")
+		if c.Retry.BackoffBase == 0 {
+			c.Retry.BackoffBase = c.NATS.JetStream.NakDelay
+		}
+		c.NATS.JetStream.NakDelay = 0
+	}
+
+	if c.Retry.MaxAttempts == 0 {
+		c.Retry.MaxAttempts = 3
+	}
+
+	if c.Retry.BackoffBase == 0 {
+		c.Retry.BackoffBase = time.Second * 30
+	}
+
+	if c.Retry.BackoffCap == 0 {
+		c.Retry.BackoffCap = time.Minute * 30
+	}
+
+	if c.Retry.BackoffCap < c.Retry.BackoffBase {
+		return errors.New("backoff_cap can't be less than backoff_base")
+	}
+
+	// Error classes are defined and validated by converter.
+	if c.Retry.RetryableErrors == nil {
+		c.Retry.RetryableErrors = []string{"input", "encode"}
+	}
+
+	if c.NATS.JetStream.Enabled && c.NATS.JetStream.MaxDeliver < c.Retry.MaxAttempts {
+		return errors.New("JetStream max_deliver can't be less than max_attempts")
+	}
+
+	return nil
+}
+
+// Checks timeouts and fills defaults.
+func (c *Config) validateTimeout() error {
+	if c.Timeout.Conversion < 0 || c.Timeout.Stall < 0 {
+		return errors.New("conversion and stall timeouts can't be negative")
+	}
+
+	if c.Timeout.Stall == 0 {
+		c.Timeout.Stall = time.Minute * 5
+	}
+
+	return nil
+}
+
+// Checks verification configuration and fills defaults.
+func (c *Config) validateVerify() error {
+	if c.Verify.DurationTolerance < 0 {
+		return errors.New("duration_tolerance can't be negative")
+	}
+
+	if c.Verify.DurationTolerance == 0 {
+		c.Verify.DurationTolerance = time.Second
 	}
 
-	log.Printf("Configuration file parsed: %+v\n", Cfg)
 	return nil
 }
@@ -1,11 +1,179 @@
 package config
 
+import (
+	// stdlib
+	"time"
+)
+
 // Config represents whole configuration file structure.
 type Config struct {
-	NATS Nats `yaml:"nats"`
+	NATS     Nats               `yaml:"nats"`
+	Profiles map[string]Profile `yaml:"profiles"`
+	Queue    Queue              `yaml:"queue"`
+	Retry    Retry              `yaml:"retry"`
+	Timeout  Timeout            `yaml:"timeout"`
+	Verify   Verify             `yaml:"verify"`
+	Shutdown Shutdown           `yaml:"shutdown"`
 }
 
 // Nats represents NATS connection configuration.
 type Nats struct {
 	ConnectionString string `yaml:"connection_string"`
+	// Authentication. Only one of credentials file, NKey seed file,
+	// user and password or token can be used.
+	CredentialsFile string `yaml:"credentials_file"`
+	NKeySeedFile    string `yaml:"nkey_seed_file"`
+	User            string `yaml:"user"`
+	Password        string `yaml:"password"`
+	Token           string `yaml:"token"`
+	// TLS configuration.
+	TLS NatsTLS `yaml:"tls"`
+	// Delay between reconnection attempts.
+	ReconnectWait time.Duration `yaml:"reconnect_wait"`
+	// How many reconnection attempts will be made before giving up.
+	// Negative value or 0 means "try forever".
+	MaxReconnects int `yaml:"max_reconnects"`
+	// Queue group for tasks topic. All ffmpeger instances with same
+	// queue group share tasks, so every task is received by only one
+	// of them. Empty means that every instance receives every task.
+	QueueGroup string `yaml:"queue_group"`
+	// Prefix for all subjects, so several deployments can share one
+	// NATS cluster. Tasks are received on prefix itself, other
+	// subjects are derived from it.
+	SubjectPrefix string `yaml:"subject_prefix"`
+	// JetStream work queue configuration.
+	JetStream JetStream `yaml:"jetstream"`
+	// Dispatcher to request tasks from.
+	Dispatcher Dispatcher `yaml:"dispatcher"`
+}
+
+// NatsTLS represents TLS configuration for NATS connection. TLS is
+// enabled if any of files is set.
+type NatsTLS struct {
+	// Client certificate and it's key, both should be set.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// CA certificate to verify server certificate with. If empty -
+	// system CAs will be used.
+	CAFile string `yaml:"ca_file"`
+}
+
+// Dispatcher represents configuration of pull mode, where tasks aren't
+// received from tasks topic but requested from dispatcher service when
+// ffmpeger has free slots.
+type Dispatcher struct {
+	// Subject where dispatcher receives work requests. Empty disables
+	// pull mode.
+	Subject string `yaml:"subject"`
+	// How long dispatcher can hold work request waiting for tasks.
+	Wait time.Duration `yaml:"wait"`
+}
+
+// JetStream represents JetStream work queue configuration. When enabled
+// tasks are taken from durable pull consumer instead of tasks topic
+// subscription, so tasks published while no ffmpeger is connected
+// aren't lost.
+type JetStream struct {
+	Enabled bool `yaml:"enabled"`
+	// Stream name. Stream will be created with work queue retention if
+	// it doesn't exist.
+	Stream string `yaml:"stream"`
+	// Durable consumer name, shared by all ffmpeger instances.
+	Consumer string `yaml:"consumer"`
+	// How long JetStream waits for acknowledgement before redelivering
+	// task. Running tasks are reported as in progress more often.
+	AckWait time.Duration `yaml:"ack_wait"`
+	// How many times task will be delivered before JetStream gives up.
+	// Should be bigger than retry max attempts, shutdowns also cause
+	// redeliveries. Failed tasks are redelivered according to retry
+	// policy.
+	MaxDeliver int `yaml:"max_deliver"`
+	// Deprecated: use retry's backoff_base. It's used as backoff_base
+	// if that isn't set.
+	NakDelay time.Duration `yaml:"nak_delay"`
+}
+
+// Profile represents single named encoding profile. Empty values
+// will not be passed to ffmpeg, so ffmpeg's defaults will be used
+// for them.
+type Profile struct {
+	// Video codec, e.g. "libx264" or "libvpx-vp9".
+	VideoCodec string `yaml:"video_codec"`
+	// Video bitrate, e.g. "1000k". Mutually exclusive with CRF.
+	VideoBitrate string `yaml:"video_bitrate"`
+	// Constant rate factor. 0 means "not set", so lossless x264
+	// encoding isn't possible via profiles.
+	CRF int `yaml:"crf"`
+	// Encoder preset, e.g. "veryfast" or "slow".
+	Preset string `yaml:"preset"`
+	// Audio codec, e.g. "aac" or "libopus".
+	AudioCodec string `yaml:"audio_codec"`
+	// Audio bitrate, e.g. "128k".
+	AudioBitrate string `yaml:"audio_bitrate"`
+	// Output container format as ffmpeg knows it, e.g. "mp4" or "webm".
+	Container string `yaml:"container"`
+}
+
+// Queue represents tasks queue persistence configuration.
+type Queue struct {
+	// Store type, "memory" (default) or "file". Memory store loses
+	// everything on restart.
+	Store string `yaml:"store"`
+	// Path to file where file store keeps tasks.
+	Path string `yaml:"path"`
+	// How many finished tasks file store should keep for the record.
+	KeepFinished int `yaml:"keep_finished"`
+	// Every aging interval spent in queue raises task's priority by
+	// one, so low-priority tasks won't wait forever.
+	AgingInterval time.Duration `yaml:"aging_interval"`
+	// Maximum tasks count waiting in queue, tasks over it are rejected.
+	// 0 means unlimited.
+	Capacity int `yaml:"capacity"`
+}
+
+// Retry represents retry policy for failed tasks. Every value can be
+// overridden by task.
+type Retry struct {
+	// How many times task will be launched before it'll be considered
+	// failed, including first attempt. 1 disables retries.
+	MaxAttempts int `yaml:"max_attempts"`
+	// Delay before first retry. It's doubled with every next retry.
+	BackoffBase time.Duration `yaml:"backoff_base"`
+	// Retry delay will never be longer than that.
+	BackoffCap time.Duration `yaml:"backoff_cap"`
+	// Classes of task errors which are worth retrying, e.g. "encode".
+	RetryableErrors []string `yaml:"retryable_errors"`
+}
+
+// Timeout represents conversion timeouts. Every value can be overridden
+// by task. ffmpeg is killed when timeout passes.
+type Timeout struct {
+	// Maximum conversion wall time. 0 means unlimited.
+	Conversion time.Duration `yaml:"conversion"`
+	// How long ffmpeg can go without progress, e.g. while reading from
+	// hung network mount.
+	Stall time.Duration `yaml:"stall"`
+}
+
+// Verify represents verification of converted files. Output file is
+// probed with ffprobe and compared with input.
+type Verify struct {
+	Enabled bool `yaml:"enabled"`
+	// Allowed difference between expected and output durations.
+	DurationTolerance time.Duration `yaml:"duration_tolerance"`
+}
+
+// Shutdown represents what should be done with running and queued
+// tasks on shutdown.
+type Shutdown struct {
+	// Shutdown mode, "drain" (default) or "kill". In drain mode running
+	// tasks are allowed to finish, "kill" kills them immediately.
+	Mode string `yaml:"mode"`
+	// How long running tasks can be drained before they'll be killed.
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+	// Where killed and queued tasks should go, "store" (default) or
+	// "nats". With "store" they'll be re-queued from persistent store
+	// on next start, "nats" publishes them back to tasks topic so other
+	// ffmpeger instance can take them.
+	Requeue string `yaml:"requeue"`
 }
@@ -0,0 +1,69 @@
+package converter
+
+import (
+	// stdlib
+	"log"
+)
+
+const (
+	// AckAccepted means that task was validated and queued.
+	AckAccepted = "accepted"
+	// AckRejected means that task wasn't queued, see Reason.
+	AckRejected = "rejected"
+)
+
+// Acknowledgement is a reply for task submitted via NATS request.
+type Acknowledgement struct {
+	Status string
+	// Assigned task ID. Empty if task was rejected.
+	TaskID string
+	// Position in tasks queue, starting from 1.
+	QueuePosition int
+	// Rejection reason.
+	Reason string
+}
+
+// ProbeReply is a reply for "probe only" task.
+type ProbeReply struct {
+	MediaInfo *MediaInfo
+	// Error message if probing failed.
+	Error string
+}
+
+// Composes acknowledgement for accepted task.
+func accepted(taskID string, queuePosition int) *Acknowledgement {
+	return &Acknowledgement{
+		Status:        AckAccepted,
+		TaskID:        taskID,
+		QueuePosition: queuePosition,
+	}
+}
+
+// Composes acknowledgement for rejected task. Used as error reply of
+// tasks handler.
+func rejected(err error) interface{} {
+	return &Acknowledgement{
+		Status: AckRejected,
+		Reason: err.Error(),
+	}
+}
+
+// Composes probe reply for error. Used as error reply of probe handler.
+func probeErrorReply(err error) interface{} {
+	return &ProbeReply{Error: err.Error()}
+}
+
+// Probes input file of "probe only" task and composes reply.
+func (c *Converter) probeReply(t *Task) *ProbeReply {
+	reply := &ProbeReply{}
+
+	mediaInfo, err := c.probe(t.InputFile, probeTimeout(c.timeoutPolicy(t)))
+	if err != nil {
+		log.Println("ERROR: failed to probe", t.InputFile+":", err.Error())
+		reply.Error = err.Error()
+	} else {
+		reply.MediaInfo = mediaInfo
+	}
+
+	return reply
+}
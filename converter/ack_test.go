@@ -0,0 +1,211 @@
+package converter
+
+import (
+	// stdlib
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	// local
+	"github.com/pztrn/ffmpeger/config"
+	"github.com/pztrn/ffmpeger/nats"
+
+	// other
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaskSubmission(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		status  string
+	}{
+		{
+			name:    "valid task",
+			message: `{"InputFile": "/tmp/in.mkv", "OutputFile": "/tmp/out.mp4"}`,
+			status:  AckAccepted,
+		},
+		{
+			name:    "not a JSON",
+			message: `Hello, world!`,
+			status:  AckRejected,
+		},
+		{
+			name:    "unknown field",
+			message: `{"input_file": "/tmp/in.mkv", "OutputFile": "/tmp/out.mp4"}`,
+			status:  AckRejected,
+		},
+		{
+			name:    "no output file",
+			message: `{"InputFile": "/tmp/in.mkv"}`,
+			status:  AckRejected,
+		},
+		{
+			name:    "unknown profile",
+			message: `{"InputFile": "/tmp/in.mkv", "OutputFile": "/tmp/out.mp4", "Profile": "nonexistent"}`,
+			status:  AckRejected,
+		},
+		{
+			name:    "probe task",
+			message: `{"Type": "probe", "InputFile": "/tmp/in.mkv"}`,
+			status:  AckRejected,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestConverter(t, &config.Config{}, 1)
+
+			ack := &Acknowledgement{}
+			err := json.Unmarshal(c.tasksHandler.Handle([]byte(test.message)), ack)
+			require.Nil(t, err)
+			require.Equal(t, test.status, ack.Status)
+
+			if test.status == AckAccepted {
+				require.NotEmpty(t, ack.TaskID)
+				require.Equal(t, 1, ack.QueuePosition)
+				require.Equal(t, 1, c.queue.Len())
+			} else {
+				require.NotEmpty(t, ack.Reason)
+				require.Equal(t, 0, c.queue.Len())
+			}
+		})
+	}
+}
+
+func TestTaskSubmissionToFullQueue(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Queue.Capacity = 2
+	c := newTestConverter(t, cfg, 1)
+
+	message := []byte(`{"InputFile": "/tmp/in.mkv", "OutputFile": "/tmp/out.mp4"}`)
+	for i := 1; i <= 3; i++ {
+		ack := &Acknowledgement{}
+		err := json.Unmarshal(c.tasksHandler.Handle(message), ack)
+		require.Nil(t, err)
+
+		if i <= 2 {
+			require.Equal(t, AckAccepted, ack.Status)
+			require.Equal(t, i, ack.QueuePosition)
+		} else {
+			require.Equal(t, AckRejected, ack.Status)
+			require.Equal(t, "queue is full", ack.Reason)
+		}
+	}
+
+	// Rejected task shouldn't be remembered.
+	storedTasks, err1 := c.store.List()
+	require.Nil(t, err1)
+	require.Len(t, storedTasks, 2)
+}
+
+func TestTaskSubmissionDuringShutdown(t *testing.T) {
+	c := newTestConverter(t, &config.Config{}, 1)
+	c.stopWorkers()
+
+	ack := &Acknowledgement{}
+	err := json.Unmarshal(c.tasksHandler.Handle([]byte(`{"InputFile": "/tmp/in.mkv", "OutputFile": "/tmp/out.mp4"}`)), ack)
+	require.Nil(t, err)
+	require.Equal(t, AckRejected, ack.Status)
+	require.Equal(t, "shutting down", ack.Reason)
+	require.Equal(t, 0, c.queue.Len())
+}
+
+func TestEnvelopedTaskSubmission(t *testing.T) {
+	c := newTestConverter(t, &config.Config{}, 1)
+
+	tests := []struct {
+		name    string
+		message string
+		status  string
+	}{
+		{
+			name:    "version 1",
+			message: `{"Version": 1, "Payload": {"InputFile": "/tmp/in.mkv", "OutputFile": "/tmp/out.mp4"}}`,
+			status:  AckAccepted,
+		},
+		{
+			name:    "unsupported version",
+			message: `{"Version": 99, "Payload": {"Input": "/tmp/in.mkv"}}`,
+			status:  AckRejected,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reply := c.tasksHandler.Handle([]byte(test.message))
+			version, payload, enveloped, err := nats.Unwrap(reply)
+			require.Nil(t, err)
+			require.True(t, enveloped)
+			require.Contains(t, test.message, `"Version": `+strconv.Itoa(version))
+
+			ack := &Acknowledgement{}
+			err1 := json.Unmarshal(payload, ack)
+			require.Nil(t, err1)
+			require.Equal(t, test.status, ack.Status)
+		})
+	}
+}
+
+func TestProbeRequest(t *testing.T) {
+	fixture, err := filepath.Abs(filepath.Join("testdata", "ffprobe_video.json"))
+	require.Nil(t, err)
+
+	dir, err1 := ioutil.TempDir("", "ffmpeger-test-probe-request")
+	if err1 != nil {
+		t.Fatal("Failed to create temporary directory:", err1.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := &config.Config{}
+	c, err2 := New(cfg, newTestClient(t, cfg), WithStore(newMemoryStore()), WithFFprobePath(writeFakeBinary(t, dir, "ffprobe", "cat "+fixture)))
+	require.Nil(t, err2)
+
+	tests := []struct {
+		name    string
+		message string
+		failed  bool
+	}{
+		{
+			name:    "probe task",
+			message: `{"Type": "probe", "InputFile": "/tmp/in.mkv"}`,
+		},
+		{
+			name:    "type omitted",
+			message: `{"InputFile": "/tmp/in.mkv"}`,
+		},
+		{
+			name:    "conversion task",
+			message: `{"Type": "convert", "InputFile": "/tmp/in.mkv", "OutputFile": "/tmp/out.mp4"}`,
+			failed:  true,
+		},
+		{
+			name:    "no input file",
+			message: `{"Type": "probe"}`,
+			failed:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reply := &ProbeReply{}
+			err3 := json.Unmarshal(c.probeHandler.Handle([]byte(test.message)), reply)
+			require.Nil(t, err3)
+
+			if test.failed {
+				require.NotEmpty(t, reply.Error)
+				require.Nil(t, reply.MediaInfo)
+				return
+			}
+
+			require.Empty(t, reply.Error)
+			require.Equal(t, 60.06, reply.MediaInfo.Duration)
+		})
+	}
+
+	// Probe tasks are never queued.
+	require.Equal(t, 0, c.queue.Len())
+}
@@ -0,0 +1,139 @@
+package converter
+
+import (
+	// stdlib
+	"strconv"
+	"time"
+
+	// local
+	"github.com/pztrn/ffmpeger/config"
+)
+
+// argumentsBuilder composes ffmpeg command line arguments from task's
+// encoding profile and per-task options.
+type argumentsBuilder struct {
+	inputFile  string
+	outputFile string
+	profile    config.Profile
+	options    Options
+
+	args []string
+}
+
+// Creates new arguments builder for passed task. Task should be
+// validated before.
+func newArgumentsBuilder(t *Task) *argumentsBuilder {
+	b := &argumentsBuilder{
+		inputFile:  t.InputFile,
+		outputFile: t.OutputFile,
+		profile:    *t.profile,
+		args:       make([]string, 0, 32),
+	}
+
+	if t.Options != nil {
+		b.options = *t.Options
+	}
+
+	return b
+}
+
+// Build returns composed arguments list.
+func (b *argumentsBuilder) Build() []string {
+	b.args = b.args[:0]
+
+	b.addInputArguments()
+	b.addVideoArguments()
+	b.addAudioArguments()
+	b.addOutputArguments()
+
+	return b.args
+}
+
+func (b *argumentsBuilder) add(args ...string) {
+	b.args = append(b.args, args...)
+}
+
+// Progress reporting, input file and seeking. Seeking is done on input
+// to avoid decoding of everything before start point.
+func (b *argumentsBuilder) addInputArguments() {
+	// Machine-readable progress goes to stdout, human-readable
+	// status line isn't needed at all.
+	b.add("-nostats", "-progress", "pipe:1")
+
+	if b.options.start != 0 {
+		b.add("-ss", formatSeconds(b.options.start))
+	}
+
+	b.add("-i", b.inputFile)
+
+	// After input seeking timestamps starts from 0, so end is passed
+	// as duration.
+	if b.options.end != 0 {
+		b.add("-t", formatSeconds(b.options.end-b.options.start))
+	}
+}
+
+func (b *argumentsBuilder) addVideoArguments() {
+	if b.profile.VideoCodec != "" {
+		b.add("-c:v", b.profile.VideoCodec)
+	}
+
+	// CRF from options overrides everything profile says about
+	// video quality.
+	switch {
+	case b.options.CRF != 0:
+		b.add("-crf", strconv.Itoa(b.options.CRF))
+	case b.profile.VideoBitrate != "":
+		b.add("-b:v", b.profile.VideoBitrate)
+	case b.profile.CRF != 0:
+		b.add("-crf", strconv.Itoa(b.profile.CRF))
+	}
+
+	if b.profile.Preset != "" {
+		b.add("-preset", b.profile.Preset)
+	}
+
+	if b.options.Width != 0 || b.options.Height != 0 {
+		// -2 tells ffmpeg to keep aspect ratio with even value.
+		width, height := "-2", "-2"
+		if b.options.Width != 0 {
+			width = strconv.Itoa(b.options.Width)
+		}
+		if b.options.Height != 0 {
+			height = strconv.Itoa(b.options.Height)
+		}
+		b.add("-vf", "scale="+width+":"+height)
+	}
+
+	if b.options.FrameRate != 0 {
+		b.add("-r", strconv.FormatFloat(b.options.FrameRate, 'f', -1, 64))
+	}
+}
+
+func (b *argumentsBuilder) addAudioArguments() {
+	if b.profile.AudioCodec != "" {
+		b.add("-c:a", b.profile.AudioCodec)
+	}
+
+	if b.profile.AudioBitrate != "" {
+		b.add("-b:a", b.profile.AudioBitrate)
+	}
+
+	if b.options.AudioChannels != 0 {
+		b.add("-ac", strconv.Itoa(b.options.AudioChannels))
+	}
+}
+
+func (b *argumentsBuilder) addOutputArguments() {
+	if b.profile.Container != "" {
+		b.add("-f", b.profile.Container)
+	}
+
+	b.add(b.outputFile, "-y")
+}
+
+// Formats duration as seconds with milliseconds precision which is
+// understood by ffmpeg.
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
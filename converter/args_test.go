@@ -0,0 +1,104 @@
+package converter
+
+import (
+	// stdlib
+	"testing"
+
+	// local
+	"github.com/pztrn/ffmpeger/config"
+
+	// other
+	"github.com/stretchr/testify/require"
+)
+
+func TestArgumentsBuilder(t *testing.T) {
+	profiles := map[string]config.Profile{
+		"webm": {
+			VideoCodec:   "libvpx-vp9",
+			CRF:          31,
+			AudioCodec:   "libopus",
+			AudioBitrate: "96k",
+			Container:    "webm",
+		},
+	}
+
+	tests := []struct {
+		name    string
+		profile string
+		options *Options
+		args    []string
+	}{
+		{
+			name: "builtin default profile",
+			args: []string{"-nostats", "-progress", "pipe:1", "-i", "in.mkv", "-c:v", "libx264", "-b:v", "1000k", "-c:a", "aac", "-f", "mp4", "out.mp4", "-y"},
+		},
+		{
+			name:    "configured profile",
+			profile: "webm",
+			args:    []string{"-nostats", "-progress", "pipe:1", "-i", "in.mkv", "-c:v", "libvpx-vp9", "-crf", "31", "-c:a", "libopus", "-b:a", "96k", "-f", "webm", "out.mp4", "-y"},
+		},
+		{
+			name:    "CRF overrides bitrate",
+			options: &Options{CRF: 20},
+			args:    []string{"-nostats", "-progress", "pipe:1", "-i", "in.mkv", "-c:v", "libx264", "-crf", "20", "-c:a", "aac", "-f", "mp4", "out.mp4", "-y"},
+		},
+		{
+			name:    "scaling by width only",
+			options: &Options{Width: 1280},
+			args:    []string{"-nostats", "-progress", "pipe:1", "-i", "in.mkv", "-c:v", "libx264", "-b:v", "1000k", "-vf", "scale=1280:-2", "-c:a", "aac", "-f", "mp4", "out.mp4", "-y"},
+		},
+		{
+			name:    "everything",
+			profile: "webm",
+			options: &Options{Width: 640, Height: 360, CRF: 35, FrameRate: 29.97, AudioChannels: 2, Start: "00:01:30", End: "120.5"},
+			args:    []string{"-nostats", "-progress", "pipe:1", "-ss", "90.000", "-i", "in.mkv", "-t", "30.500", "-c:v", "libvpx-vp9", "-crf", "35", "-vf", "scale=640:360", "-r", "29.97", "-c:a", "libopus", "-b:a", "96k", "-ac", "2", "-f", "webm", "out.mp4", "-y"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			task := &Task{
+				InputFile:  "in.mkv",
+				OutputFile: "out.mp4",
+				Profile:    test.profile,
+				Options:    test.options,
+			}
+			require.Nil(t, task.validate(profiles))
+			require.Equal(t, test.args, newArgumentsBuilder(task).Build())
+		})
+	}
+}
+
+func TestTaskValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+		options *Options
+		retry   *RetryPolicy
+	}{
+		{name: "unknown profile", profile: "nonexistent"},
+		{name: "negative width", options: &Options{Width: -1280}},
+		{name: "odd height", options: &Options{Height: 361}},
+		{name: "CRF out of range", options: &Options{CRF: 64}},
+		{name: "too many audio channels", options: &Options{AudioChannels: 16}},
+		{name: "bad start", options: &Options{Start: "1:2:3:4"}},
+		{name: "NaN start", options: &Options{Start: "NaN"}},
+		{name: "infinite start", options: &Options{Start: "+Inf"}},
+		{name: "infinite end", options: &Options{End: "00:Inf"}},
+		{name: "end before start", options: &Options{Start: "10", End: "5"}},
+		{name: "bad retry policy", retry: &RetryPolicy{RetryableErrors: []string{"unknown"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			task := &Task{
+				InputFile:  "in.mkv",
+				OutputFile: "out.mp4",
+				Profile:    test.profile,
+				Options:    test.options,
+				Retry:      test.retry,
+			}
+			require.NotNil(t, task.validate(nil))
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package converter
+
+import (
+	// stdlib
+	"errors"
+	"log"
+)
+
+const (
+	// CommandCancel cancels queued or running task.
+	CommandCancel = "cancel"
+)
+
+// ControlCommand represents command received via control topic (see
+// nats.Subjects).
+type ControlCommand struct {
+	// Command, see Command* constants.
+	Command string
+	TaskID  string
+}
+
+// ControlReply is a reply for control command.
+type ControlReply struct {
+	Success bool
+	// Error message if command failed.
+	Error string
+}
+
+// Executes control command.
+func (c *Converter) handleControlCommand(msg interface{}) (interface{}, error) {
+	cmd, ok := msg.(*ControlCommand)
+	if !ok {
+		return nil, errors.New("unexpected control message")
+	}
+
+	log.Printf("Received control command: %+v\n", cmd)
+
+	switch cmd.Command {
+	case CommandCancel:
+		err := c.cancelTask(cmd.TaskID)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ControlReply{Success: true}, nil
+	}
+
+	return nil, errors.New("unknown command '" + cmd.Command + "'")
+}
+
+// Composes reply for failed control command.
+func controlErrorReply(err error) interface{} {
+	return &ControlReply{Error: err.Error()}
+}
+
+// Cancels task with passed ID. Queued task or task waiting for retry
+// will be removed and reported as cancelled immediately, running task will be killed
+// and reported by it's converting goroutine.
+func (c *Converter) cancelTask(taskID string) error {
+	if taskID == "" {
+		return errors.New("task ID isn't specified")
+	}
+
+	// Queue lock is held while looking into running tasks because
+	// tasks are moved from queue to running tasks under it.
+	c.tasksMutex.Lock()
+	defer c.tasksMutex.Unlock()
+
+	t := c.queue.Remove(taskID)
+	if t == nil {
+		t = c.removeRetrying(taskID)
+	}
+	if t != nil {
+		log.Println("Task", taskID, "removed from queue")
+
+		t.Error = newTaskError(ErrorCancelled, errors.New("task was cancelled before start"))
+		c.setStatus(t, StatusCancelled)
+		c.acknowledge(t)
+		c.publishResult(&Result{
+			TaskID:   taskID,
+			Status:   StatusCancelled,
+			Error:    t.Error,
+			Attempt:  t.Attempts,
+			ExitCode: -1,
+		})
+
+		return nil
+	}
+
+	c.runningTasksMutex.Lock()
+	t, found := c.runningTasks[taskID]
+	c.runningTasksMutex.Unlock()
+	if !found {
+		return errors.New("task " + taskID + " isn't queued or running")
+	}
+
+	select {
+	case t.cancel <- true:
+		log.Println("Cancellation requested for running task", taskID)
+	default:
+		// Cancellation was already requested.
+	}
+
+	return nil
+}
+
+// Marks task as running so it can be cancelled. Should be called with
+// tasksMutex locked.
+func (c *Converter) markRunning(t *Task) {
+	t.cancel = make(chan bool, 1)
+
+	c.runningTasksMutex.Lock()
+	c.runningTasks[t.ID] = t
+	c.runningTasksMutex.Unlock()
+}
+
+// Removes task from running tasks.
+func (c *Converter) unmarkRunning(t *Task) {
+	c.runningTasksMutex.Lock()
+	delete(c.runningTasks, t.ID)
+	c.runningTasksMutex.Unlock()
+}
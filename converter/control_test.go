@@ -0,0 +1,92 @@
+package converter
+
+import (
+	// stdlib
+	"encoding/json"
+	"testing"
+
+	// local
+	"github.com/pztrn/ffmpeger/config"
+	"github.com/pztrn/ffmpeger/nats"
+
+	// other
+	"github.com/stretchr/testify/require"
+)
+
+func sendControlCommand(t *testing.T, c *Converter, message string) *ControlReply {
+	reply := &ControlReply{}
+	err := json.Unmarshal(c.controlHandler.Handle([]byte(message)), reply)
+	require.Nil(t, err)
+
+	return reply
+}
+
+func TestCancelQueuedTask(t *testing.T) {
+	c := newTestConverter(t, &config.Config{}, 1)
+
+	queued := &Task{ID: "queued", InputFile: "/tmp/in.mkv", OutputFile: "/tmp/out.mp4"}
+	another := &Task{ID: "another", InputFile: "/tmp/in.mkv", OutputFile: "/tmp/out.mp4"}
+	c.AddTask(queued)
+	c.AddTask(another)
+
+	reply := sendControlCommand(t, c, `{"Command": "cancel", "TaskID": "queued"}`)
+	require.True(t, reply.Success)
+	require.Empty(t, reply.Error)
+	require.Equal(t, StatusCancelled, queued.Status)
+	require.Equal(t, 1, c.queue.Len())
+	require.Equal(t, "another", c.queue.Pop().ID)
+
+	// Already cancelled.
+	reply1 := sendControlCommand(t, c, `{"Command": "cancel", "TaskID": "queued"}`)
+	require.False(t, reply1.Success)
+	require.NotEmpty(t, reply1.Error)
+}
+
+func TestCancelRunningTask(t *testing.T) {
+	c := newTestConverter(t, &config.Config{}, 1)
+
+	running := &Task{ID: "running", InputFile: "/tmp/in.mkv", OutputFile: "/tmp/out.mp4"}
+	c.markRunning(running)
+
+	reply := sendControlCommand(t, c, `{"Command": "cancel", "TaskID": "running"}`)
+	require.True(t, reply.Success)
+	require.Len(t, running.cancel, 1)
+
+	// Repeated cancellation shouldn't block.
+	reply1 := sendControlCommand(t, c, `{"Command": "cancel", "TaskID": "running"}`)
+	require.True(t, reply1.Success)
+
+	c.unmarkRunning(running)
+	reply2 := sendControlCommand(t, c, `{"Command": "cancel", "TaskID": "running"}`)
+	require.False(t, reply2.Success)
+}
+
+func TestBadControlCommands(t *testing.T) {
+	c := newTestConverter(t, &config.Config{}, 1)
+
+	for _, message := range []string{
+		`Hello, world!`,
+		`{"Command": "restart", "TaskID": "task"}`,
+		`{"Command": "cancel"}`,
+		`{"Command": "cancel", "ID": "task"}`,
+	} {
+		reply := sendControlCommand(t, c, message)
+		require.False(t, reply.Success, message)
+		require.NotEmpty(t, reply.Error, message)
+	}
+}
+
+func TestEnvelopedControlCommand(t *testing.T) {
+	c := newTestConverter(t, &config.Config{}, 1)
+
+	reply := c.controlHandler.Handle([]byte(`{"Version": 2, "Payload": {"Command": "cancel", "TaskID": "task"}}`))
+	version, payload, enveloped, err := nats.Unwrap(reply)
+	require.Nil(t, err)
+	require.True(t, enveloped)
+	require.Equal(t, 2, version)
+
+	controlReply := &ControlReply{}
+	require.Nil(t, json.Unmarshal(payload, controlReply))
+	require.False(t, controlReply.Success)
+	require.Contains(t, controlReply.Error, "schema version")
+}
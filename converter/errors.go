@@ -0,0 +1,46 @@
+package converter
+
+const (
+	// ErrorInput means that input file can't be opened or probed.
+	ErrorInput = "input"
+	// ErrorInvalid means that task is invalid or input file isn't
+	// suitable for the task. Such tasks will fail every time, so they're
+	// never retried.
+	ErrorInvalid = "invalid"
+	// ErrorStart means that ffmpeg can't be launched or refused to start
+	// conversion, e.g. because of unknown encoder.
+	ErrorStart = "start"
+	// ErrorEncode means that ffmpeg failed to convert input file.
+	ErrorEncode = "encode"
+	// ErrorTimeout means that conversion took too long or ffmpeg made no
+	// progress for too long.
+	ErrorTimeout = "timeout"
+	// ErrorVerification means that ffmpeg succeeded but output file is
+	// unreadable or doesn't match input.
+	ErrorVerification = "verification"
+	// ErrorCancelled means that task was cancelled via control topic.
+	ErrorCancelled = "cancelled"
+	// ErrorInterrupted means that task was interrupted by shutdown and
+	// can't be returned to JetStream, because it was delivered too many
+	// times.
+	ErrorInterrupted = "interrupted"
+)
+
+// TaskError describes why task wasn't converted. It's recorded on task
+// and included into result.
+type TaskError struct {
+	// Error class, see Error* constants.
+	Class   string
+	Message string
+}
+
+func newTaskError(class string, err error) *TaskError {
+	return &TaskError{
+		Class:   class,
+		Message: err.Error(),
+	}
+}
+
+func (e *TaskError) Error() string {
+	return e.Class + " error: " + e.Message
+}
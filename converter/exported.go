@@ -2,186 +2,346 @@ package converter
 
 import (
 	// stdlib
-	"encoding/json"
-	"flag"
+	"errors"
 	"log"
 	"sync"
-	"time"
 
 	// local
+	"github.com/pztrn/ffmpeger/config"
 	"github.com/pztrn/ffmpeger/nats"
+
+	// other
+	"github.com/nats-io/nuid"
 )
 
-var (
-	// ffmpeg path.
-	ffmpegPath string
+// Converter receives tasks via NATS and converts them with ffmpeg.
+// Every converter needs it's own NATS client because only one handler
+// can be added for every subject.
+type Converter struct {
+	cfg    *config.Config
+	client *nats.Client
+
+	// ffmpeg and ffprobe paths. Looked up in PATH on start if empty.
+	ffmpegPath  string
+	ffprobePath string
 
 	// Tasks queue.
-	tasks      []*Task
+	queue      *taskQueue
 	tasksMutex sync.Mutex
 
-	// Currently running tasks.
-	// Reason why this isn't from atomic package is because atomic's
-	// integers (as well as other things) doesn't neccessarily changed
-	// when Add* functions called but we need to make sure that our
-	// running count is precise.
-	// Mutex is here because value will be decremented/incremented from
-	// worker goroutine and read from control goroutine.
-	currentlyRunning      int
-	currentlyRunningMutex sync.Mutex
-
-	// Maximum tasks that should be executed concurrently.
-	// No mutex here because it will be accessed from only one place
-	// after initialization.
+	// Signalled when tasks are added to queue or when shutdown was
+	// requested. Uses tasksMutex.
+	tasksAvailable *sync.Cond
+
+	// Maximum tasks that should be executed concurrently, which is
+	// also a workers count.
 	maximumConcurrentTasks int
 
-	// Indicates that we should shutdown working goroutine.
-	shouldShutdown      bool
-	shouldShutdownMutex sync.Mutex
+	// Indicates that we should shutdown. Protected by tasksMutex.
+	shouldShutdown bool
+	// Closed together with setting shouldShutdown, so waiting for
+	// dispatcher can be interrupted.
+	stopRequested chan struct{}
+	// Closed when shutdown was requested, so running tasks will be
+	// notified immediately.
+	shutdownRequested chan struct{}
 
-	// Indicates that goroutine was successfully shutdown.
-	shuttedDown chan bool
-)
+	// Workers pool.
+	workers sync.WaitGroup
+	// Closed when all workers are stopped.
+	workersStopped chan struct{}
 
-// AddTask adds task to processing queue.
-func AddTask(task *Task) {
-	tasksMutex.Lock()
-	tasks = append(tasks, task)
-	tasksMutex.Unlock()
-}
+	// Tasks store.
+	store Store
 
-// Initialize initializes package.
-func Initialize() {
-	log.Println("Initializing converter...")
+	// Tasks that are launched, by ID. Used for cancellation.
+	runningTasks      map[string]*Task
+	runningTasksMutex sync.Mutex
+
+	// Failed tasks waiting for retry, by ID.
+	retrying      map[string]*retryingTask
+	retryingMutex sync.Mutex
+
+	// Launches task. Tests replace conversion with something cheaper.
+	runTask func(t *Task) error
 
-	tasks = make([]*Task, 0, 64)
-	shuttedDown = make(chan bool, 1)
+	// Identifies this converter for dispatcher.
+	instanceID string
 
-	flag.IntVar(&maximumConcurrentTasks, "maxconcurrency", 1, "Maximum conversion tasks that should be run concurrently")
+	// Nil in JetStream and pull modes.
+	tasksHandler   *nats.Handler
+	probeHandler   *nats.Handler
+	controlHandler *nats.Handler
+}
+
+// Option configures converter.
+type Option func(c *Converter)
 
-	handler := &nats.Handler{
-		Name: "converter",
-		Func: natsMessageHandler,
+// WithMaxConcurrency sets maximum tasks that should be run
+// concurrently. Default is 1.
+func WithMaxConcurrency(tasks int) Option {
+	return func(c *Converter) {
+		c.maximumConcurrentTasks = tasks
 	}
-	nats.AddHandler(handler)
 }
 
-func natsMessageHandler(data []byte) {
-	t := &Task{}
-	json.Unmarshal(data, t)
-	log.Printf("Received task: %+v\n", t)
+// WithFFmpegPath sets path to ffmpeg binary, so it won't be looked up
+// in PATH.
+func WithFFmpegPath(path string) Option {
+	return func(c *Converter) {
+		c.ffmpegPath = path
+	}
+}
 
-	tasksMutex.Lock()
-	tasks = append(tasks, t)
-	tasksMutex.Unlock()
+// WithFFprobePath sets path to ffprobe binary, so it won't be looked
+// up in PATH.
+func WithFFprobePath(path string) Option {
+	return func(c *Converter) {
+		c.ffprobePath = path
+	}
 }
 
-// Shutdown sets shutdown flag and waits until shuttedDown channel will
-// get any message means that shutdown was completed.
-func Shutdown() {
-	log.Println("Starting converter shutdown...")
-	shouldShutdownMutex.Lock()
-	shouldShutdown = true
-	shouldShutdownMutex.Unlock()
+// WithStore sets tasks store which will be used instead of configured
+// one.
+func WithStore(store Store) Option {
+	return func(c *Converter) {
+		c.store = store
+	}
+}
 
-	<-shuttedDown
-	log.Println("Converter shutted down")
+// New creates converter with passed configuration. Tasks and control
+// commands will be received via passed NATS client, which should be
+// connected with StartListening separately.
+func New(cfg *config.Config, client *nats.Client, options ...Option) (*Converter, error) {
+	log.Println("Initializing converter...")
+
+	err := cfg.Validate()
+	if err != nil {
+		return nil, errors.New("Invalid configuration: " + err.Error())
+	}
+
+	err1 := validateRetryableErrors(cfg.Retry.RetryableErrors)
+	if err1 != nil {
+		return nil, errors.New("Invalid retry configuration: " + err1.Error())
+	}
+
+	c := &Converter{
+		cfg:                    cfg,
+		client:                 client,
+		queue:                  newTaskQueue(cfg.Queue.AgingInterval),
+		maximumConcurrentTasks: 1,
+		shutdownRequested:      make(chan struct{}),
+		stopRequested:          make(chan struct{}),
+		runningTasks:           make(map[string]*Task),
+		retrying:               make(map[string]*retryingTask),
+		instanceID:             nuid.Next(),
+		workersStopped:         make(chan struct{}),
+	}
+	c.tasksAvailable = sync.NewCond(&c.tasksMutex)
+	// There is nothing to wait for if shutdown happens before start.
+	close(c.workersStopped)
+	c.runTask = c.convert
+
+	for _, option := range options {
+		option(c)
+	}
+
+	if c.maximumConcurrentTasks < 1 {
+		return nil, errors.New("maximum concurrent tasks should be positive")
+	}
+
+	if c.store == nil {
+		s, err2 := newStore(&cfg.Queue)
+		if err2 != nil {
+			return nil, errors.New("Failed to open tasks store: " + err2.Error())
+		}
+		c.store = s
+	}
+
+	c.probeHandler = c.newProbeHandler()
+	c.controlHandler = c.newControlHandler()
+	handlers := []*nats.Handler{c.probeHandler, c.controlHandler}
+	// In JetStream and pull modes tasks aren't received from tasks
+	// topic.
+	if !cfg.NATS.JetStream.Enabled && cfg.NATS.Dispatcher.Subject == "" {
+		c.tasksHandler = c.newTasksHandler()
+		handlers = append(handlers, c.tasksHandler)
+	}
+	for i, hndl := range handlers {
+		err3 := client.AddHandler(hndl)
+		if err3 != nil {
+			// Client might be used by something else, so it shouldn't
+			// be left with half of our handlers.
+			for _, added := range handlers[:i] {
+				_ = client.RemoveHandler(added.Name)
+			}
+
+			return nil, errors.New("Failed to add NATS handler: " + err3.Error())
+		}
+	}
+
+	return c, nil
 }
 
-// Start starts working goroutine.
-func Start() {
-	log.Println("Starting converter controlling goroutine...")
-	log.Println("Maximum simultaneous tasks to run:", maximumConcurrentTasks)
-	findffmpeg()
+// AddTask adds task to processing queue.
+func (c *Converter) AddTask(task *Task) {
+	if task.ID == "" {
+		task.ID = nuid.Next()
+	}
+	c.setStatus(task, StatusQueued)
+	c.enqueue(task)
+}
+
+// Handles task received from tasks topic. Conversion tasks are queued,
+// probe tasks should be sent to probe subject.
+func (c *Converter) handleTask(msg interface{}) (interface{}, error) {
+	t, err := c.acceptTask(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Type == TaskTypeProbe {
+		return nil, errors.New("probe tasks should be sent as requests to " + c.client.Subjects().Probe)
+	}
+
+	queuePosition, err1 := c.tryEnqueue(t)
+	if err1 != nil {
+		return nil, err1
+	}
 
-	go startReally()
+	return accepted(t.ID, queuePosition), nil
 }
 
-// Real start for working goroutine.
-func startReally() {
-	tick := time.NewTicker(time.Second * 1)
-	for range tick.C {
-		// Check for shutdown.
-		// Boolean values aren't goroutine-safe that's why we create local
-		// copy of package variable.
-		shouldShutdownMutex.Lock()
-		weHaveToShutdown := shouldShutdown
-		shouldShutdownMutex.Unlock()
-
-		if weHaveToShutdown {
-			log.Println("Stopping tasks distribution...")
-			break
-		}
+// Handles media inspection request. Task type can be omitted.
+func (c *Converter) handleProbe(msg interface{}) (interface{}, error) {
+	task, ok := msg.(*Task)
+	if ok && task.Type == "" {
+		task.Type = TaskTypeProbe
+	}
+
+	t, err := c.acceptTask(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Type != TaskTypeProbe {
+		return nil, errors.New("only probe tasks can be sent to " + c.client.Subjects().Probe)
+	}
 
-		// Check for tasks available and currently running counts.
-		currentlyRunningMutex.Lock()
-		curRunning := currentlyRunning
-		currentlyRunningMutex.Unlock()
+	return c.probeReply(t), nil
+}
+
+// Shutdown stops receiving new tasks and taking tasks from queue and
+// waits until running tasks will be finished. Depending on shutdown mode
+// running tasks will be killed immediately or after drain timeout.
+// Killed and queued tasks are re-queued as configured. Control requests
+// are handled until shutdown is completed, so tasks can be cancelled
+// while draining.
+func (c *Converter) Shutdown() {
+	log.Println("Starting converter shutdown...")
 
-		// Skip iteration if we have maximum tasks launched.
-		if curRunning >= maximumConcurrentTasks {
+	for _, hndl := range []*nats.Handler{c.tasksHandler, c.probeHandler} {
+		if hndl == nil {
 			continue
 		}
 
-		// Check if we have tasks at all.
-		tasksMutex.Lock()
-		tasksCount := len(tasks)
-		tasksMutex.Unlock()
-		if tasksCount == 0 {
-			log.Println("No tasks to launch")
-			continue
+		err := c.client.RemoveHandler(hndl.Name)
+		if err != nil {
+			log.Println("ERROR: failed to remove handler:", err.Error())
 		}
+	}
 
-		// If we're here - we should launch a task! Lets get them.
-		tasksToRunCount := maximumConcurrentTasks - curRunning
-		tasksToRun := make([]*Task, 0, tasksToRunCount)
-		tasksMutex.Lock()
-		// To ensure that our tasks queue will be clean we will copy
-		// queue, clear it and re-add if queue items still be there.
-		tasksQueue := make([]*Task, 0, tasksCount)
-		tasksQueue = append(tasksQueue, tasks...)
-		tasks = make([]*Task, 0, 64)
-		tasksMutex.Unlock()
-
-		// Get tasks list to launch.
-		for taskID, task := range tasksQueue {
-			if taskID == tasksToRunCount {
-				break
-			}
-			tasksToRun = append(tasksToRun, task)
+	c.stopWorkers()
+
+	if c.cfg.Shutdown.Mode == "drain" {
+		log.Println("Waiting", c.cfg.Shutdown.DrainTimeout, "for running tasks to finish...")
+		if !c.waitWorkers(c.cfg.Shutdown.DrainTimeout) {
+			log.Println("Drain timeout passed, killing running tasks...")
 		}
-		// Remove tasks that will be launched now.
-		tasksQueue = tasksQueue[tasksToRunCount:]
-		log.Println("Tasks count that will be returned to main queue:", len(tasksQueue))
-		// Re-add remaining tasks to queue.
-		// Note: if another task was added to queue while we compose
-		// our tasks list to launch - it will be executed BEFORE remaining
-		// tasks.
-		tasksMutex.Lock()
-		tasks = append(tasks, tasksQueue...)
-		tasksMutex.Unlock()
-
-		log.Println("Got", len(tasksToRun), "tasks to run")
-
-		// Launch tasks.
-		for _, task := range tasksToRun {
-			go task.Convert()
+	}
+
+	c.killRunningTasks()
+
+	log.Println("Waiting for all workers to stop...")
+	<-c.workersStopped
+
+	c.requeueRemaining()
+
+	err1 := c.client.RemoveHandler(c.controlHandler.Name)
+	if err1 != nil {
+		log.Println("ERROR: failed to remove control handler:", err1.Error())
+	}
+
+	err2 := c.store.Close()
+	if err2 != nil {
+		log.Println("ERROR: failed to close tasks store:", err2.Error())
+	}
+
+	log.Println("Converter shutted down")
+}
+
+// Start starts workers.
+func (c *Converter) Start() error {
+	log.Println("Starting converter workers...")
+	log.Println("Maximum simultaneous tasks to run:", c.maximumConcurrentTasks)
+	if c.ffmpegPath == "" {
+		err := c.findffmpeg()
+		if err != nil {
+			return err
 		}
 	}
+	if c.ffprobePath == "" {
+		err1 := c.findffprobe()
+		if err1 != nil {
+			return err1
+		}
+	}
+
+	// JetStream redelivers unfinished tasks itself.
+	if !c.cfg.NATS.JetStream.Enabled {
+		err2 := c.restoreTasks()
+		if err2 != nil {
+			return errors.New("Failed to restore tasks from store: " + err2.Error())
+		}
+	}
+
+	c.startWorkers()
+
+	return nil
+}
+
+// Re-queues tasks that was queued or running when ffmpeger was stopped.
+func (c *Converter) restoreTasks() error {
+	storedTasks, err := c.store.List()
+	if err != nil {
+		return err
+	}
 
-	// Waiting until all child goroutines will also shut down.
-	log.Println("Waiting for all child goroutines to stop...")
-	shutdownTicker := time.NewTicker(time.Millisecond * 500)
-	for range shutdownTicker.C {
-		currentlyRunningMutex.Lock()
-		curRunning := currentlyRunning
-		currentlyRunningMutex.Unlock()
+	var restored int
+	for _, t := range storedTasks {
+		if isFinalStatus(t.Status) {
+			continue
+		}
 
-		log.Println("Currently running converter goroutines:", curRunning)
-		if curRunning == 0 {
-			break
+		if t.Status == StatusRunning {
+			log.Println("Task", t.ID, "was interrupted, re-queuing it")
 		}
+
+		// Profiles might be changed since task was queued.
+		err1 := t.validate(c.cfg.Profiles)
+		if err1 != nil {
+			log.Println("ERROR: stored task", t.ID, "is invalid now:", err1.Error())
+			t.Error = newTaskError(ErrorInvalid, err1)
+			c.setStatus(t, StatusFailed)
+			continue
+		}
+
+		c.setStatus(t, StatusQueued)
+		c.enqueue(t)
+		restored++
 	}
 
-	shuttedDown <- true
+	log.Println("Tasks restored from store:", restored)
+
+	return nil
 }
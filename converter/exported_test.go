@@ -0,0 +1,98 @@
+package converter
+
+import (
+	// stdlib
+	"testing"
+	"time"
+
+	// local
+	"github.com/pztrn/ffmpeger/config"
+
+	// other
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewConverter(t *testing.T) {
+	cfg := &config.Config{}
+
+	_, err := New(cfg, newTestClient(t, cfg), WithStore(newMemoryStore()), WithMaxConcurrency(0))
+	require.NotNil(t, err)
+
+	// Handlers can't be added twice to same client.
+	client := newTestClient(t, cfg)
+	_, err1 := New(cfg, client, WithStore(newMemoryStore()))
+	require.Nil(t, err1)
+	_, err2 := New(cfg, client, WithStore(newMemoryStore()))
+	require.NotNil(t, err2)
+
+	// Unknown store type.
+	cfg1 := &config.Config{}
+	cfg1.Queue.Store = "redis"
+	_, err3 := New(cfg1, newTestClient(t, cfg1))
+	require.NotNil(t, err3)
+
+	// Tasks topic isn't subscribed in pull mode, probes are served
+	// anyway.
+	cfg3 := &config.Config{}
+	cfg3.NATS.Dispatcher.Subject = "dispatcher"
+	c, err5 := New(cfg3, newTestClient(t, cfg3), WithStore(newMemoryStore()))
+	require.Nil(t, err5)
+	require.Nil(t, c.tasksHandler)
+	require.NotNil(t, c.probeHandler)
+	c.Shutdown()
+
+	// Cancelled tasks can't be retried.
+	cfg2 := &config.Config{}
+	cfg2.Retry.RetryableErrors = []string{ErrorCancelled}
+	_, err4 := New(cfg2, newTestClient(t, cfg2), WithStore(newMemoryStore()))
+	require.NotNil(t, err4)
+}
+
+func TestShutdownWithoutStart(t *testing.T) {
+	c := newTestConverter(t, &config.Config{}, 1)
+
+	done := make(chan struct{})
+	go func() {
+		c.Shutdown()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown of converter which wasn't started hangs")
+	}
+
+	// Handlers are removed, so client can be reused.
+	_, err := New(c.cfg, c.client, WithStore(newMemoryStore()))
+	require.Nil(t, err)
+}
+
+func TestTwoConvertersInOneProcess(t *testing.T) {
+	started := make(chan string, 2)
+	first := newTestConverter(t, &config.Config{}, 1)
+	second := newTestConverter(t, &config.Config{}, 1)
+
+	stopFirst := startTestWorkers(first, func(t *Task) {
+		started <- "first " + t.ID
+	})
+	defer stopFirst()
+	stopSecond := startTestWorkers(second, func(t *Task) {
+		started <- "second " + t.ID
+	})
+	defer stopSecond()
+
+	first.AddTask(&Task{ID: "a"})
+	second.AddTask(&Task{ID: "b"})
+
+	received := make([]string, 0, 2)
+	for i := 0; i < 2; i++ {
+		select {
+		case s := <-started:
+			received = append(received, s)
+		case <-time.After(time.Second):
+			t.Fatal("Task wasn't started in 1 second")
+		}
+	}
+	require.ElementsMatch(t, []string{"first a", "second b"}, received)
+}
@@ -0,0 +1,79 @@
+package converter
+
+import (
+	// stdlib
+	"strconv"
+	"strings"
+)
+
+// Known ffmpeg's error messages and classes of failures they mean.
+var failureMessages = []struct {
+	message string
+	class   string
+}{
+	{"Invalid data found when processing input", ErrorInput},
+	{"moov atom not found", ErrorInput},
+	{"could not find codec parameters", ErrorInput},
+	{"Unknown encoder", ErrorStart},
+	{"Encoder not found", ErrorStart},
+	{"Unrecognized option", ErrorStart},
+	{"Error initializing output stream", ErrorStart},
+	{"No space left on device", ErrorEncode},
+}
+
+// Classifies ffmpeg's failure by it's exit code and last stderr lines.
+// Last known error message found in stderr determines error class,
+// otherwise failure is treated as encode error. Returns nil if ffmpeg
+// exited successfully.
+func classifyFailure(t *Task, exitCode int, stderrTail []string) *TaskError {
+	if exitCode == 0 {
+		return nil
+	}
+
+	reason := "ffmpeg exited with code " + strconv.Itoa(exitCode)
+	if exitCode == -1 {
+		reason = "ffmpeg was killed"
+	}
+
+	for i := len(stderrTail) - 1; i >= 0; i-- {
+		class := classifyStderrLine(t, stderrTail[i])
+		if class != "" {
+			return &TaskError{
+				Class:   class,
+				Message: reason + ": " + stderrTail[i],
+			}
+		}
+	}
+
+	// Last line is usually the most informative one.
+	if len(stderrTail) > 0 {
+		reason += ": " + stderrTail[len(stderrTail)-1]
+	}
+
+	return &TaskError{
+		Class:   ErrorEncode,
+		Message: reason,
+	}
+}
+
+// Returns class of failure which passed stderr line means, or empty
+// string if line isn't a known error message.
+func classifyStderrLine(t *Task, line string) string {
+	// ffmpeg reports file errors as "<path>: <error>", only errors of
+	// input file are input errors.
+	if strings.HasSuffix(line, "No such file or directory") || strings.HasSuffix(line, "Permission denied") {
+		if strings.HasPrefix(line, t.InputFile+":") {
+			return ErrorInput
+		}
+
+		return ErrorEncode
+	}
+
+	for _, known := range failureMessages {
+		if strings.Contains(line, known.message) {
+			return known.class
+		}
+	}
+
+	return ""
+}
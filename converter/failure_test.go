@@ -0,0 +1,96 @@
+package converter
+
+import (
+	// stdlib
+	"testing"
+
+	// other
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	task := &Task{InputFile: "/tmp/in.mkv", OutputFile: "/tmp/out/out.mp4"}
+
+	tests := []struct {
+		name     string
+		exitCode int
+		stderr   []string
+		class    string
+		message  string
+	}{
+		{
+			name:     "succeeded",
+			exitCode: 0,
+			stderr:   []string{"Invalid data found when processing input"},
+		},
+		{
+			name:     "no stderr",
+			exitCode: 1,
+			class:    ErrorEncode,
+			message:  "ffmpeg exited with code 1",
+		},
+		{
+			name:     "unknown message",
+			exitCode: 1,
+			stderr:   []string{"frame=1", "something went wrong"},
+			class:    ErrorEncode,
+			message:  "ffmpeg exited with code 1: something went wrong",
+		},
+		{
+			name:     "broken input",
+			exitCode: 1,
+			stderr:   []string{"[mov,mp4] moov atom not found", "/tmp/in.mkv: Invalid data found when processing input"},
+			class:    ErrorInput,
+			message:  "ffmpeg exited with code 1: /tmp/in.mkv: Invalid data found when processing input",
+		},
+		{
+			name:     "missing input",
+			exitCode: 1,
+			stderr:   []string{"/tmp/in.mkv: No such file or directory"},
+			class:    ErrorInput,
+			message:  "ffmpeg exited with code 1: /tmp/in.mkv: No such file or directory",
+		},
+		{
+			name:     "missing output directory",
+			exitCode: 1,
+			stderr:   []string{"/tmp/out/out.mp4: No such file or directory"},
+			class:    ErrorEncode,
+			message:  "ffmpeg exited with code 1: /tmp/out/out.mp4: No such file or directory",
+		},
+		{
+			name:     "unknown encoder",
+			exitCode: 1,
+			stderr:   []string{"Unknown encoder 'libx265'"},
+			class:    ErrorStart,
+			message:  "ffmpeg exited with code 1: Unknown encoder 'libx265'",
+		},
+		{
+			name:     "known message isn't last",
+			exitCode: 1,
+			stderr:   []string{"av_interleaved_write_frame(): No space left on device", "Conversion failed!"},
+			class:    ErrorEncode,
+			message:  "ffmpeg exited with code 1: av_interleaved_write_frame(): No space left on device",
+		},
+		{
+			name:     "killed",
+			exitCode: -1,
+			stderr:   []string{"frame=100"},
+			class:    ErrorEncode,
+			message:  "ffmpeg was killed: frame=100",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			taskErr := classifyFailure(task, test.exitCode, test.stderr)
+			if test.class == "" {
+				require.Nil(t, taskErr)
+				return
+			}
+
+			require.NotNil(t, taskErr)
+			require.Equal(t, test.class, taskErr.Class)
+			require.Equal(t, test.message, taskErr.Message)
+		})
+	}
+}
@@ -3,34 +3,50 @@ package converter
 import (
 	// stdlib
 	"bytes"
+	"errors"
 	"log"
 	"os/exec"
 	"strings"
 )
 
-func findffmpeg() {
+func (c *Converter) findffmpeg() error {
 	// Search for ffmpeg.
 	var err error
-	ffmpegPath, err = exec.LookPath("ffmpeg")
+	c.ffmpegPath, err = exec.LookPath("ffmpeg")
 	if err != nil {
-		log.Fatalln("Failed to find ffmpeg in path:", err.Error())
+		return errors.New("Failed to find ffmpeg in path: " + err.Error())
 	}
 
 	// Get ffmpeg version.
 	stdout := bytes.NewBuffer(nil)
-	ffmpegVersionCmd := exec.Command(ffmpegPath, "-version")
+	ffmpegVersionCmd := exec.Command(c.ffmpegPath, "-version")
 	ffmpegVersionCmd.Stdout = stdout
 	err1 := ffmpegVersionCmd.Run()
 	if err1 != nil {
-		log.Fatalln("Failed to get ffmpeg version:", err1.Error())
+		return errors.New("Failed to get ffmpeg version: " + err1.Error())
 	}
 
-	stdoutString := stdout.String()
-	if len(stdoutString) == 0 {
-		log.Fatalln("Something weird happened and '" + ffmpegPath + " -version' returns nothing! Check your ffmpeg installation!")
-	}
 	// ffmpeg prints it's version on line 1.
-	ffmpegVersion := strings.Split(stdoutString, " ")[2]
+	versionLine := strings.Fields(stdout.String())
+	if len(versionLine) < 3 {
+		return errors.New("Something weird happened and '" + c.ffmpegPath + " -version' returns nothing! Check your ffmpeg installation!")
+	}
+	ffmpegVersion := versionLine[2]
+
+	log.Println("ffmpeg found at", c.ffmpegPath, "with version", ffmpegVersion)
+
+	return nil
+}
+
+func (c *Converter) findffprobe() error {
+	// ffprobe is usually installed along with ffmpeg.
+	var err error
+	c.ffprobePath, err = exec.LookPath("ffprobe")
+	if err != nil {
+		return errors.New("Failed to find ffprobe in path: " + err.Error())
+	}
+
+	log.Println("ffprobe found at", c.ffprobePath)
 
-	log.Println("ffmpeg found at", ffmpegPath, "with version", ffmpegVersion)
+	return nil
 }
@@ -0,0 +1,170 @@
+package converter
+
+import (
+	// stdlib
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	// local
+	"github.com/pztrn/ffmpeger/nats"
+)
+
+const (
+	// How long single fetch request waits for tasks.
+	fetchWait = time.Second * 5
+)
+
+// Fetches tasks from JetStream consumer while there are free slots.
+func (c *Converter) fetchTasks() {
+	defer c.workers.Done()
+
+	log.Println("JetStream tasks fetcher started")
+
+	for {
+		free := c.waitFreeSlots()
+		if free == 0 {
+			break
+		}
+
+		messages, err := c.client.Fetch(free, fetchWait)
+		if err != nil {
+			log.Println("ERROR: failed to fetch tasks from JetStream:", err.Error())
+			time.Sleep(time.Second)
+		}
+
+		for _, msg := range messages {
+			c.receiveJetStreamMessage(msg)
+		}
+	}
+
+	log.Println("JetStream tasks fetcher stopped")
+}
+
+// Queues task received from JetStream. Task ID is a stream sequence, so
+// it stays same when task is redelivered.
+func (c *Converter) receiveJetStreamMessage(msg *nats.JetStreamMessage) {
+	t, err := c.decodeTask(msg.Data)
+	if err != nil {
+		log.Println("ERROR: dropping invalid task from JetStream:", err.Error())
+		terminateMessage(msg)
+		return
+	}
+
+	// Nobody will receive probe reply.
+	if t.Type == TaskTypeProbe {
+		log.Println("ERROR: dropping probe task from JetStream, probe tasks should be sent as requests to", c.client.Subjects().Probe)
+		terminateMessage(msg)
+		return
+	}
+
+	t.ID = strconv.FormatUint(msg.Sequence(), 10)
+	t.jetStreamMessage = msg
+	// Shutdowns also cause redeliveries, so it's not precise.
+	t.Attempts = msg.Deliveries() - 1
+	if msg.Deliveries() > 1 {
+		log.Println("Task", t.ID, "is delivered", msg.Deliveries(), "times")
+	}
+
+	c.setStatus(t, StatusQueued)
+	c.enqueue(t)
+}
+
+func terminateMessage(msg *nats.JetStreamMessage) {
+	err := msg.Term()
+	if err != nil {
+		log.Println("ERROR: failed to terminate JetStream message:", err.Error())
+	}
+}
+
+// Tells JetStream that task is still running while it runs, otherwise
+// it'll be redelivered after ack wait. Returned function stops that.
+func (c *Converter) reportInProgress(t *Task) func() {
+	if t.jetStreamMessage == nil {
+		return func() {}
+	}
+
+	done := make(chan bool)
+	go func() {
+		ticker := time.NewTicker(c.cfg.NATS.JetStream.AckWait / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := t.jetStreamMessage.InProgress()
+				if err != nil {
+					log.Println("ERROR: failed to report task", t.ID, "progress to JetStream:", err.Error())
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
+
+// Acknowledges JetStream message of finished task. Succeeded, cancelled
+// and failed tasks are removed from stream, tasks which should be
+// retried will be redelivered after retry delay.
+func (c *Converter) acknowledge(t *Task) {
+	if t.jetStreamMessage == nil {
+		return
+	}
+
+	var err error
+	switch t.Status {
+	case StatusSucceeded, StatusCancelled:
+		err = t.jetStreamMessage.Ack()
+	case StatusRetrying:
+		delay := retryDelay(c.retryPolicy(t), t.Attempts)
+		log.Println("Task", t.ID, "will be redelivered in", delay)
+		err = t.jetStreamMessage.Nak(delay)
+	case StatusFailed:
+		// Failed task was published to dead letter subject already.
+		err = t.jetStreamMessage.Term()
+	default:
+		// Not finished yet.
+		return
+	}
+
+	if err != nil {
+		log.Println("ERROR: failed to acknowledge task", t.ID, "in JetStream:", err.Error())
+	}
+}
+
+// Returns unfinished task to JetStream so it will be redelivered
+// immediately, possibly to another ffmpeger instance.
+func (c *Converter) returnToJetStream(t *Task) {
+	// JetStream won't redeliver task that was delivered max_deliver
+	// times, so it would be lost silently.
+	deliveries := t.jetStreamMessage.Deliveries()
+	if deliveries >= c.cfg.NATS.JetStream.MaxDeliver {
+		log.Println("ERROR: task", t.ID, "was delivered", deliveries, "times and can't be returned to JetStream")
+
+		t.Error = newTaskError(ErrorInterrupted, errors.New("task was interrupted by shutdown after "+strconv.Itoa(deliveries)+" deliveries"))
+		c.setStatus(t, StatusFailed)
+		c.publishResult(&Result{
+			TaskID:   t.ID,
+			Status:   StatusFailed,
+			Error:    t.Error,
+			Attempt:  t.Attempts,
+			ExitCode: -1,
+		})
+		c.deadLetter(t)
+		terminateMessage(t.jetStreamMessage)
+		return
+	}
+
+	err := t.jetStreamMessage.Nak(0)
+	if err != nil {
+		log.Println("ERROR: failed to return task", t.ID, "to JetStream:", err.Error())
+		return
+	}
+
+	c.setStatus(t, StatusRequeued)
+}
@@ -0,0 +1,118 @@
+package converter
+
+import (
+	// stdlib
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options represents per-task encoding overrides. They're applied on
+// top of selected encoding profile. Zero values means "not set".
+type Options struct {
+	// Target resolution. If only one of them is set then another
+	// will be calculated by ffmpeg to keep aspect ratio.
+	Width  int
+	Height int
+	// Constant rate factor. Overrides profile's video bitrate or CRF.
+	CRF int
+	// Output frame rate.
+	FrameRate float64
+	// Output audio channels count.
+	AudioChannels int
+	// Trimming. Accepts either seconds ("90.5") or "[HH:]MM:SS[.ms]"
+	// ("00:01:30.5").
+	Start string
+	End   string
+
+	// Parsed trimming values.
+	start time.Duration
+	end   time.Duration
+}
+
+// Validates options and parses trimming values.
+func (o *Options) validate() error {
+	if o.Width < 0 || o.Height < 0 {
+		return errors.New("width and height can't be negative")
+	}
+
+	// Most of encoders (and yuv420p pixel format) requires even
+	// dimensions.
+	if o.Width%2 != 0 || o.Height%2 != 0 {
+		return errors.New("width and height should be even")
+	}
+
+	if o.CRF < 0 || o.CRF > 63 {
+		return errors.New("CRF should be in 0-63 range")
+	}
+
+	if o.FrameRate < 0 {
+		return errors.New("frame rate can't be negative")
+	}
+
+	if o.AudioChannels < 0 || o.AudioChannels > 8 {
+		return errors.New("audio channels count should be in 0-8 range")
+	}
+
+	if o.Start != "" {
+		start, err := parseTimestamp(o.Start)
+		if err != nil {
+			return errors.New("invalid start value: " + err.Error())
+		}
+		o.start = start
+	}
+
+	if o.End != "" {
+		end, err := parseTimestamp(o.End)
+		if err != nil {
+			return errors.New("invalid end value: " + err.Error())
+		}
+		o.end = end
+
+		if o.end <= o.start {
+			return errors.New("end should be after start")
+		}
+	}
+
+	return nil
+}
+
+// Returns expected output duration for passed input duration with
+// trimming applied. Options might be nil.
+func (o *Options) outputDuration(inputDuration time.Duration) time.Duration {
+	if o == nil || inputDuration == 0 {
+		return inputDuration
+	}
+
+	end := inputDuration
+	if o.end != 0 && o.end < inputDuration {
+		end = o.end
+	}
+
+	if o.start >= end {
+		return 0
+	}
+
+	return end - o.start
+}
+
+// Parses timestamp in "[HH:]MM:SS[.ms]" format or plain seconds.
+func parseTimestamp(timestamp string) (time.Duration, error) {
+	parts := strings.Split(timestamp, ":")
+	if len(parts) > 3 {
+		return 0, errors.New("too many ':' in '" + timestamp + "'")
+	}
+
+	var seconds float64
+	for _, part := range parts {
+		value, err := strconv.ParseFloat(part, 64)
+		if err != nil || value < 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+			return 0, errors.New("'" + timestamp + "' isn't a valid timestamp")
+		}
+		seconds = seconds*60 + value
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
+}
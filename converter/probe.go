@@ -0,0 +1,264 @@
+package converter
+
+import (
+	// stdlib
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	// local
+	"github.com/pztrn/ffmpeger/config"
+)
+
+const (
+	// StreamTypeVideo is a type of video streams.
+	StreamTypeVideo = "video"
+	// StreamTypeAudio is a type of audio streams.
+	StreamTypeAudio = "audio"
+)
+
+// MediaInfo represents media file information obtained with ffprobe.
+type MediaInfo struct {
+	// Container format as ffprobe reports it, e.g. "mov,mp4,m4a,3gp,3g2,mj2".
+	Container string
+	// Duration in seconds.
+	Duration float64
+	// Overall bitrate in bits per second.
+	Bitrate int64
+	// File size in bytes.
+	Size    int64
+	Streams []StreamInfo
+}
+
+// StreamInfo represents single stream of media file.
+type StreamInfo struct {
+	Index int
+	// Stream type, see StreamType* constants. Might also be "subtitle",
+	// "data" or "attachment".
+	Type  string
+	Codec string
+	// Stream bitrate in bits per second, 0 if unknown.
+	Bitrate int64
+	// Stream language from metadata, if any.
+	Language string
+
+	// Video streams information.
+	Width     int
+	Height    int
+	FrameRate float64
+	// Clockwise rotation in degrees (0, 90, 180 or 270) from display
+	// matrix or metadata.
+	Rotation int
+
+	// Audio streams information.
+	Channels      int
+	ChannelLayout string
+	SampleRate    int
+}
+
+// DurationValue returns duration as time.Duration.
+func (mi *MediaInfo) DurationValue() time.Duration {
+	return time.Duration(mi.Duration * float64(time.Second))
+}
+
+// VideoStream returns first video stream or nil if there is no video.
+func (mi *MediaInfo) VideoStream() *StreamInfo {
+	for i := range mi.Streams {
+		if mi.Streams[i].Type == StreamTypeVideo {
+			return &mi.Streams[i]
+		}
+	}
+
+	return nil
+}
+
+// AudioStream returns first audio stream or nil if there is no audio.
+func (mi *MediaInfo) AudioStream() *StreamInfo {
+	for i := range mi.Streams {
+		if mi.Streams[i].Type == StreamTypeAudio {
+			return &mi.Streams[i]
+		}
+	}
+
+	return nil
+}
+
+// ffprobe's JSON output structure. Most of numbers are reported by
+// ffprobe as strings.
+type ffprobeOutput struct {
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		BitRate    string `json:"bit_rate"`
+		Size       string `json:"size"`
+	} `json:"format"`
+	Streams []struct {
+		Index         int    `json:"index"`
+		CodecType     string `json:"codec_type"`
+		CodecName     string `json:"codec_name"`
+		Width         int    `json:"width"`
+		Height        int    `json:"height"`
+		AvgFrameRate  string `json:"avg_frame_rate"`
+		RFrameRate    string `json:"r_frame_rate"`
+		BitRate       string `json:"bit_rate"`
+		Channels      int    `json:"channels"`
+		ChannelLayout string `json:"channel_layout"`
+		SampleRate    string `json:"sample_rate"`
+		Tags          struct {
+			Language string `json:"language"`
+			Rotate   string `json:"rotate"`
+		} `json:"tags"`
+		SideDataList []struct {
+			Rotation int `json:"rotation"`
+		} `json:"side_data_list"`
+	} `json:"streams"`
+}
+
+// Returned by probe when ffprobe was killed because it didn't finish in
+// time.
+var errProbeTimeout = errors.New("ffprobe didn't finish in time")
+
+// Probe runs ffprobe against passed file and returns information
+// about it. ffprobe is killed if it runs longer than configured stall
+// or conversion timeout.
+func (c *Converter) Probe(path string) (*MediaInfo, error) {
+	return c.probe(path, probeTimeout(c.cfg.Timeout))
+}
+
+// Returns how long ffprobe can run with passed timeouts. ffprobe reports
+// no progress, so the shortest of them is used.
+func probeTimeout(timeout config.Timeout) time.Duration {
+	if timeout.Stall > 0 && (timeout.Conversion == 0 || timeout.Stall < timeout.Conversion) {
+		return timeout.Stall
+	}
+
+	return timeout.Conversion
+}
+
+// Runs ffprobe which will be killed with it's process group after
+// passed timeout or on shutdown. Zero timeout means unlimited.
+func (c *Converter) probe(path string, timeout time.Duration) (*MediaInfo, error) {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+
+	go func() {
+		select {
+		case <-c.shutdownRequested:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	stdout := bytes.NewBuffer(nil)
+	stderr := bytes.NewBuffer(nil)
+
+	ffprobeCmd := exec.CommandContext(ctx, c.ffprobePath, "-v", "error", "-print_format", "json", "-show_format", "-show_streams", path)
+	ffprobeCmd.Stdout = stdout
+	ffprobeCmd.Stderr = stderr
+	setProcessGroup(ffprobeCmd)
+	ffprobeCmd.Cancel = func() error {
+		return killProcessGroup(ffprobeCmd)
+	}
+
+	err := ffprobeCmd.Run()
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, errProbeTimeout
+		}
+		return nil, errors.New("ffprobe failed: " + err.Error() + ": " + strings.TrimSpace(stderr.String()))
+	}
+
+	return parseProbeOutput(stdout.Bytes())
+}
+
+// Parses ffprobe's JSON output.
+func parseProbeOutput(data []byte) (*MediaInfo, error) {
+	output := &ffprobeOutput{}
+	err := json.Unmarshal(data, output)
+	if err != nil {
+		return nil, errors.New("failed to parse ffprobe output: " + err.Error())
+	}
+
+	mi := &MediaInfo{
+		Container: output.Format.FormatName,
+		Streams:   make([]StreamInfo, 0, len(output.Streams)),
+	}
+	mi.Duration, _ = strconv.ParseFloat(output.Format.Duration, 64)
+	mi.Bitrate, _ = strconv.ParseInt(output.Format.BitRate, 10, 64)
+	mi.Size, _ = strconv.ParseInt(output.Format.Size, 10, 64)
+
+	for _, stream := range output.Streams {
+		si := StreamInfo{
+			Index:         stream.Index,
+			Type:          stream.CodecType,
+			Codec:         stream.CodecName,
+			Language:      stream.Tags.Language,
+			Width:         stream.Width,
+			Height:        stream.Height,
+			Channels:      stream.Channels,
+			ChannelLayout: stream.ChannelLayout,
+		}
+		si.Bitrate, _ = strconv.ParseInt(stream.BitRate, 10, 64)
+		si.SampleRate, _ = strconv.Atoi(stream.SampleRate)
+
+		if si.Type == StreamTypeVideo {
+			// Average frame rate is what we need for variable frame
+			// rate videos, but it might be unknown.
+			si.FrameRate = parseFrameRate(stream.AvgFrameRate)
+			if si.FrameRate == 0 {
+				si.FrameRate = parseFrameRate(stream.RFrameRate)
+			}
+
+			// Newer ffprobe reports rotation in display matrix side
+			// data, older - in "rotate" tag with opposite sign.
+			for _, sideData := range stream.SideDataList {
+				if sideData.Rotation != 0 {
+					si.Rotation = -sideData.Rotation
+				}
+			}
+			if si.Rotation == 0 && stream.Tags.Rotate != "" {
+				si.Rotation, _ = strconv.Atoi(stream.Tags.Rotate)
+			}
+			si.Rotation = (si.Rotation%360 + 360) % 360
+		}
+
+		mi.Streams = append(mi.Streams, si)
+	}
+
+	return mi, nil
+}
+
+// Parses frame rate which ffprobe reports as fraction, e.g. "30000/1001".
+// Returns 0 if frame rate is unknown.
+func parseFrameRate(frameRate string) float64 {
+	parts := strings.SplitN(frameRate, "/", 2)
+
+	numerator, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0
+	}
+
+	if len(parts) == 1 {
+		return numerator
+	}
+
+	denominator, err1 := strconv.ParseFloat(parts[1], 64)
+	if err1 != nil || denominator == 0 {
+		return 0
+	}
+
+	return numerator / denominator
+}
@@ -0,0 +1,168 @@
+package converter
+
+import (
+	// stdlib
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	// local
+	"github.com/pztrn/ffmpeger/config"
+
+	// other
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProbeOutput(t *testing.T) {
+	tests := []struct {
+		fixture   string
+		mediaInfo *MediaInfo
+	}{
+		{
+			fixture: "ffprobe_video.json",
+			mediaInfo: &MediaInfo{
+				Container: "mov,mp4,m4a,3gp,3g2,mj2",
+				Duration:  60.06,
+				Bitrate:   5205031,
+				Size:      39077264,
+				Streams: []StreamInfo{
+					{
+						Index:     0,
+						Type:      StreamTypeVideo,
+						Codec:     "h264",
+						Bitrate:   4821133,
+						Language:  "und",
+						Width:     1920,
+						Height:    1080,
+						FrameRate: 30000.0 / 1001.0,
+						Rotation:  90,
+					},
+					{
+						Index:         1,
+						Type:          StreamTypeAudio,
+						Codec:         "aac",
+						Bitrate:       384000,
+						Language:      "eng",
+						Channels:      6,
+						ChannelLayout: "5.1",
+						SampleRate:    48000,
+					},
+				},
+			},
+		},
+		{
+			fixture: "ffprobe_audio.json",
+			mediaInfo: &MediaInfo{
+				Container: "flac",
+				Duration:  180,
+				Bitrate:   910176,
+				Size:      20478976,
+				Streams: []StreamInfo{
+					{
+						Index:         0,
+						Type:          StreamTypeAudio,
+						Codec:         "flac",
+						Channels:      2,
+						ChannelLayout: "stereo",
+						SampleRate:    44100,
+					},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			data, err := ioutil.ReadFile(filepath.Join("testdata", test.fixture))
+			if err != nil {
+				t.Fatal("Failed to read fixture:", err.Error())
+			}
+
+			mediaInfo, err1 := parseProbeOutput(data)
+			require.Nil(t, err1)
+			require.Equal(t, test.mediaInfo, mediaInfo)
+		})
+	}
+}
+
+func TestParseProbeOutputMalformed(t *testing.T) {
+	_, err := parseProbeOutput([]byte("Invalid data found when processing input"))
+	require.NotNil(t, err)
+}
+
+func TestCheckMediaInfo(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "ffprobe_audio.json"))
+	if err != nil {
+		t.Fatal("Failed to read fixture:", err.Error())
+	}
+
+	audioOnly, err1 := parseProbeOutput(data)
+	require.Nil(t, err1)
+	require.Nil(t, audioOnly.VideoStream())
+	require.NotNil(t, audioOnly.AudioStream())
+	require.Equal(t, time.Minute*3, audioOnly.DurationValue())
+
+	tests := []struct {
+		name    string
+		options *Options
+		valid   bool
+	}{
+		{name: "no options", valid: true},
+		{name: "audio options", options: &Options{AudioChannels: 1}, valid: true},
+		{name: "video options", options: &Options{Width: 640}, valid: false},
+		{name: "start beyond duration", options: &Options{Start: "00:05:00"}, valid: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			task := &Task{Options: test.options}
+			if task.Options != nil {
+				require.Nil(t, task.Options.validate())
+			}
+
+			err := task.checkMediaInfo(audioOnly)
+			if test.valid {
+				require.Nil(t, err)
+			} else {
+				require.NotNil(t, err)
+			}
+		})
+	}
+
+	require.NotNil(t, (&Task{}).checkMediaInfo(&MediaInfo{}))
+}
+
+func TestProbeIsKilled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ffmpeger-test-probe")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	// sleep holds output pipes, so probe will return only if whole
+	// process group is killed.
+	c := &Converter{
+		ffprobePath:       writeFakeBinary(t, dir, "ffprobe", "sleep 30"),
+		shutdownRequested: make(chan struct{}),
+	}
+
+	startedAt := time.Now()
+	_, err1 := c.probe("/tmp/in.mkv", time.Millisecond*200)
+	require.Equal(t, errProbeTimeout, err1)
+	require.True(t, time.Since(startedAt) < time.Second*10)
+
+	time.AfterFunc(time.Millisecond*200, func() { close(c.shutdownRequested) })
+	_, err2 := c.probe("/tmp/in.mkv", 0)
+	require.NotNil(t, err2)
+	require.NotEqual(t, errProbeTimeout, err2)
+	require.True(t, time.Since(startedAt) < time.Second*10)
+}
+
+func TestProbeTimeout(t *testing.T) {
+	require.Equal(t, time.Duration(0), probeTimeout(config.Timeout{}))
+	require.Equal(t, time.Minute, probeTimeout(config.Timeout{Stall: time.Minute}))
+	require.Equal(t, time.Hour, probeTimeout(config.Timeout{Conversion: time.Hour}))
+	require.Equal(t, time.Second, probeTimeout(config.Timeout{Conversion: time.Second, Stall: time.Minute}))
+}
@@ -0,0 +1,18 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package converter
+
+import (
+	// stdlib
+	"os/exec"
+)
+
+// Process groups aren't supported here, so only ffmpeg itself will be
+// killed.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// Kills launched ffmpeg.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,22 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package converter
+
+import (
+	// stdlib
+	"os/exec"
+	"syscall"
+)
+
+// Makes ffmpeg a leader of new process group, so everything it
+// launches can be killed along with it.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// Kills launched ffmpeg's process group.
+func killProcessGroup(cmd *exec.Cmd) error {
+	// Negative PID means whole process group.
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
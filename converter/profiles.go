@@ -0,0 +1,45 @@
+package converter
+
+import (
+	// stdlib
+	"errors"
+
+	// local
+	"github.com/pztrn/ffmpeger/config"
+)
+
+const (
+	// DefaultProfileName is a name of profile that will be used if
+	// task doesn't specify one.
+	DefaultProfileName = "default"
+)
+
+// Built-in profile which will be used as "default" if configuration
+// file doesn't define profile with such name. It reproduces what
+// ffmpeger was doing before profiles was introduced.
+var builtinDefaultProfile = config.Profile{
+	VideoCodec:   "libx264",
+	VideoBitrate: "1000k",
+	AudioCodec:   "aac",
+	Container:    "mp4",
+}
+
+// Returns profile with passed name from profiles. Empty name means
+// default profile.
+func getProfile(profiles map[string]config.Profile, name string) (*config.Profile, error) {
+	if name == "" {
+		name = DefaultProfileName
+	}
+
+	profile, found := profiles[name]
+	if found {
+		return &profile, nil
+	}
+
+	if name == DefaultProfileName {
+		profile = builtinDefaultProfile
+		return &profile, nil
+	}
+
+	return nil, errors.New("unknown encoding profile '" + name + "'")
+}
@@ -0,0 +1,156 @@
+package converter
+
+import (
+	// stdlib
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Minimal interval between two progress events for single task.
+	progressInterval = time.Second
+)
+
+// Progress represents conversion progress event which is published
+// to task's progress topic (see nats.Subjects).
+type Progress struct {
+	TaskID string
+	// Percentage done, 0 if it can't be calculated.
+	Percent float64
+	// Current frame.
+	Frame int
+	// Encoding frames per second.
+	FPS float64
+	// Encoding speed relative to realtime, e.g. 2.5 means that 1 second
+	// of media is encoded in 0.4 seconds.
+	Speed float64
+	// Current output bitrate in kbits/s.
+	Bitrate float64
+	// Estimated time to finish in seconds, 0 if it can't be calculated.
+	ETA float64
+	// Output size so far in bytes.
+	OutputSize int64
+}
+
+// progressBlock is a single progress report from ffmpeg's "-progress"
+// output. Values that ffmpeg reports as "N/A" are left zero.
+type progressBlock struct {
+	Frame     int
+	FPS       float64
+	Bitrate   float64
+	TotalSize int64
+	OutTime   time.Duration
+	Speed     float64
+	// Indicates that this is the last report.
+	End bool
+}
+
+// Returns true if ffmpeg made some progress since previous report.
+func (b *progressBlock) advancedFrom(previous *progressBlock) bool {
+	return b.End || b.Frame != previous.Frame || b.OutTime != previous.OutTime || b.TotalSize != previous.TotalSize
+}
+
+// progressParser parses ffmpeg's "-progress" output which is a stream
+// of "key=value" lines grouped into blocks, each block is finished
+// with "progress=continue" or "progress=end" line.
+type progressParser struct {
+	current progressBlock
+}
+
+// Parse consumes single line of ffmpeg's "-progress" output. If line
+// finishes the block then parsed block will be returned with true.
+func (p *progressParser) Parse(line string) (*progressBlock, bool) {
+	keyValue := strings.SplitN(strings.TrimSpace(line), "=", 2)
+	if len(keyValue) != 2 {
+		return nil, false
+	}
+
+	key, value := keyValue[0], strings.TrimSpace(keyValue[1])
+
+	switch key {
+	case "frame":
+		p.current.Frame, _ = strconv.Atoi(value)
+	case "fps":
+		p.current.FPS, _ = strconv.ParseFloat(value, 64)
+	case "bitrate":
+		p.current.Bitrate, _ = strconv.ParseFloat(strings.TrimSuffix(value, "kbits/s"), 64)
+	case "total_size":
+		p.current.TotalSize, _ = strconv.ParseInt(value, 10, 64)
+	case "out_time_us":
+		// ffmpeg might report negative values before first frame
+		// was encoded.
+		outTime, err := strconv.ParseInt(value, 10, 64)
+		if err == nil && outTime > 0 {
+			p.current.OutTime = time.Duration(outTime) * time.Microsecond
+		}
+	case "speed":
+		p.current.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+	case "progress":
+		p.current.End = value == "end"
+		block := p.current
+		p.current = progressBlock{}
+		return &block, true
+	}
+
+	return nil, false
+}
+
+// Publishes progress event composed from ffmpeg's progress report if
+// it's time to do so. Last report is always published.
+func (c *Converter) workWithProgress(t *Task, block *progressBlock) {
+	if !block.End && time.Since(t.lastProgressAt) < progressInterval {
+		return
+	}
+
+	t.lastProgressAt = time.Now()
+	c.publishProgress(newProgress(t.ID, t.Options.outputDuration(t.mediaInfo.DurationValue()), block))
+}
+
+// Composes progress event from ffmpeg's progress report. Percentage
+// and ETA are calculated only if expected output duration is known.
+func newProgress(taskID string, outputDuration time.Duration, block *progressBlock) *Progress {
+	progress := &Progress{
+		TaskID:     taskID,
+		Frame:      block.Frame,
+		FPS:        block.FPS,
+		Speed:      block.Speed,
+		Bitrate:    block.Bitrate,
+		OutputSize: block.TotalSize,
+	}
+
+	if outputDuration > 0 {
+		progress.Percent = block.OutTime.Seconds() / outputDuration.Seconds() * 100
+		// Output might be a little bit longer than input.
+		if progress.Percent > 100 {
+			progress.Percent = 100
+		}
+
+		if block.Speed > 0 && outputDuration > block.OutTime {
+			progress.ETA = (outputDuration - block.OutTime).Seconds() / block.Speed
+		}
+	}
+
+	if block.End {
+		progress.Percent = 100
+		progress.ETA = 0
+	}
+
+	return progress
+}
+
+// Publishes progress event to NATS.
+func (c *Converter) publishProgress(progress *Progress) {
+	data, err := json.Marshal(progress)
+	if err != nil {
+		log.Println("ERROR: failed to encode progress:", err.Error())
+		return
+	}
+
+	err1 := c.client.Publish(c.client.Subjects().Progress(progress.TaskID), data)
+	if err1 != nil {
+		log.Println("ERROR: failed to publish progress:", err1.Error())
+	}
+}
@@ -0,0 +1,187 @@
+package converter
+
+import (
+	// stdlib
+	"bufio"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	// other
+	"github.com/stretchr/testify/require"
+)
+
+// Parses recorded ffmpeg's "-progress" output.
+func parseProgressFixture(t *testing.T, name string) []*progressBlock {
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal("Failed to open fixture:", err.Error())
+	}
+	defer f.Close()
+
+	parser := &progressParser{}
+	blocks := make([]*progressBlock, 0, 8)
+
+	scanner := bufio.NewScanner(f)
+	scanner.Split(scanOutputLines)
+	for scanner.Scan() {
+		block, completed := parser.Parse(scanner.Text())
+		if completed {
+			blocks = append(blocks, block)
+		}
+	}
+
+	return blocks
+}
+
+func TestProgressParser(t *testing.T) {
+	tests := []struct {
+		fixture string
+		blocks  int
+		last    progressBlock
+	}{
+		{
+			fixture: "progress_video.txt",
+			blocks:  5,
+			last: progressBlock{
+				Frame:     250,
+				FPS:       124.51,
+				Bitrate:   497.3,
+				TotalSize: 621640,
+				OutTime:   time.Second * 10,
+				Speed:     4.98,
+				End:       true,
+			},
+		},
+		{
+			fixture: "progress_audio.txt",
+			blocks:  2,
+			last: progressBlock{
+				Bitrate:   128,
+				TotalSize: 2883628,
+				OutTime:   time.Minute * 3,
+				Speed:     134,
+				End:       true,
+			},
+		},
+		{
+			fixture: "progress_short.txt",
+			blocks:  1,
+			last: progressBlock{
+				Frame:     24,
+				Bitrate:   95.4,
+				TotalSize: 11924,
+				OutTime:   time.Second,
+				Speed:     2.1,
+				End:       true,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			blocks := parseProgressFixture(t, test.fixture)
+			require.Len(t, blocks, test.blocks)
+			require.Equal(t, test.last, *blocks[len(blocks)-1])
+
+			for _, block := range blocks[:len(blocks)-1] {
+				require.False(t, block.End)
+			}
+		})
+	}
+}
+
+func TestProgressParserNotAvailableValues(t *testing.T) {
+	blocks := parseProgressFixture(t, "progress_video.txt")
+	require.Equal(t, progressBlock{}, *blocks[0])
+}
+
+func TestNewProgress(t *testing.T) {
+	blocks := parseProgressFixture(t, "progress_video.txt")
+
+	tests := []struct {
+		name           string
+		block          *progressBlock
+		outputDuration time.Duration
+		percent        float64
+		eta            float64
+	}{
+		{
+			name:           "in the middle",
+			block:          blocks[3],
+			outputDuration: time.Second * 10,
+			percent:        69,
+			eta:            3.1 / 4.57,
+		},
+		{
+			name:    "unknown duration",
+			block:   blocks[3],
+			percent: 0,
+			eta:     0,
+		},
+		{
+			name:           "nothing encoded yet",
+			block:          blocks[0],
+			outputDuration: time.Second * 10,
+			percent:        0,
+			eta:            0,
+		},
+		{
+			name:           "end",
+			block:          blocks[4],
+			outputDuration: time.Second * 10,
+			percent:        100,
+			eta:            0,
+		},
+		{
+			name:           "output is longer than expected",
+			block:          blocks[3],
+			outputDuration: time.Second * 5,
+			percent:        100,
+			eta:            0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			progress := newProgress("test", test.outputDuration, test.block)
+			require.Equal(t, "test", progress.TaskID)
+			require.Equal(t, test.block.Frame, progress.Frame)
+			require.InDelta(t, test.percent, progress.Percent, 0.001)
+			require.InDelta(t, test.eta, progress.ETA, 0.001)
+		})
+	}
+}
+
+func TestOutputDurationWithTrimming(t *testing.T) {
+	var noOptions *Options
+	require.Equal(t, time.Minute, noOptions.outputDuration(time.Minute))
+
+	options := &Options{Start: "10", End: "40"}
+	require.Nil(t, options.validate())
+	require.Equal(t, time.Second*30, options.outputDuration(time.Minute))
+	require.Equal(t, time.Second*10, options.outputDuration(time.Second*20))
+	require.Equal(t, time.Duration(0), options.outputDuration(time.Second*5))
+}
+
+func TestProgressBlockAdvanced(t *testing.T) {
+	previous := &progressBlock{Frame: 10, OutTime: time.Second, TotalSize: 1024}
+
+	tests := []struct {
+		name     string
+		block    *progressBlock
+		advanced bool
+	}{
+		{name: "same values", block: &progressBlock{Frame: 10, OutTime: time.Second, TotalSize: 1024, Speed: 1}},
+		{name: "new frame", block: &progressBlock{Frame: 11, OutTime: time.Second, TotalSize: 1024}, advanced: true},
+		{name: "audio only", block: &progressBlock{Frame: 10, OutTime: time.Second * 2, TotalSize: 1024}, advanced: true},
+		{name: "last report", block: &progressBlock{Frame: 10, OutTime: time.Second, TotalSize: 1024, End: true}, advanced: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.advanced, test.block.advancedFrom(previous))
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package converter
+
+import (
+	// stdlib
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+const (
+	// Delay before asking dispatcher again after it had no tasks for us
+	// or failed to reply.
+	pullRetryDelay = time.Second
+	// How long we wait for reply after dispatcher wait, so idle
+	// dispatcher's empty reply doesn't race with our deadline.
+	pullReplyMargin = time.Second * 5
+)
+
+// WorkRequest is sent to dispatcher when ffmpeger has free slots.
+type WorkRequest struct {
+	// Instance ID, unique for every launched ffmpeger.
+	Instance string
+	// How many tasks ffmpeger can launch right now.
+	Slots int
+	// Tasks received after shutdown was started, in tasks topic format.
+	// They're given back to dispatcher with zero slots and dispatcher
+	// should hand them to other instances.
+	Returned []json.RawMessage
+}
+
+// WorkReply is a dispatcher's reply for WorkRequest. It shouldn't
+// contain more tasks than requested, every task has same format as
+// tasks sent to tasks topic.
+type WorkReply struct {
+	Tasks []json.RawMessage
+}
+
+// Requests tasks from dispatcher while there are free slots.
+func (c *Converter) pullTasks() {
+	defer c.workers.Done()
+
+	log.Println("Tasks puller started, instance ID:", c.instanceID)
+
+	for {
+		free := c.waitFreeSlots()
+		if free == 0 {
+			break
+		}
+
+		received, err := c.requestWork(free)
+		if err != nil {
+			log.Println("ERROR: failed to request tasks from dispatcher:", err.Error())
+		}
+
+		if received == 0 {
+			select {
+			case <-c.stopRequested:
+			case <-time.After(pullRetryDelay):
+			}
+		}
+	}
+
+	log.Println("Tasks puller stopped")
+}
+
+// Requests up to slots tasks from dispatcher and queues them. Returns
+// received tasks count.
+func (c *Converter) requestWork(slots int) (int, error) {
+	data, err := json.Marshal(&WorkRequest{
+		Instance: c.instanceID,
+		Slots:    slots,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Dispatcher might hold request for a long time, shutdown shouldn't
+	// wait for it.
+	dispatcher := &c.cfg.NATS.Dispatcher
+	ctx, cancel := context.WithTimeout(context.Background(), dispatcher.Wait+pullReplyMargin)
+	defer cancel()
+	go func() {
+		select {
+		case <-c.stopRequested:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	replyData, err1 := c.client.RequestWithContext(ctx, dispatcher.Subject, data)
+	if err1 != nil {
+		// Shutdown was started or dispatcher had no tasks for us and
+		// didn't reply at all.
+		if ctx.Err() != nil {
+			return 0, nil
+		}
+
+		return 0, err1
+	}
+
+	reply := &WorkReply{}
+	err2 := json.Unmarshal(replyData, reply)
+	if err2 != nil {
+		return 0, err2
+	}
+
+	// Reply might come right after shutdown was started.
+	select {
+	case <-c.stopRequested:
+		c.returnWork(reply.Tasks)
+		return len(reply.Tasks), nil
+	default:
+	}
+
+	if len(reply.Tasks) > slots {
+		log.Println("ERROR: dispatcher sent", len(reply.Tasks), "tasks while", slots, "was requested")
+	}
+
+	for _, taskData := range reply.Tasks {
+		t, err3 := c.decodeTask(taskData)
+		if err3 != nil {
+			log.Println("ERROR: dispatcher sent invalid task:", err3.Error())
+			continue
+		}
+
+		if t.Type == TaskTypeProbe {
+			log.Println("ERROR: dispatcher sent probe task, probe tasks aren't supported in pull mode")
+			continue
+		}
+
+		c.setStatus(t, StatusQueued)
+		c.enqueue(t)
+	}
+
+	return len(reply.Tasks), nil
+}
+
+// Gives tasks received after shutdown was started back to dispatcher.
+// If dispatcher can't be reached tasks are queued, so they'll be handled
+// like other tasks left in queue.
+func (c *Converter) returnWork(tasks []json.RawMessage) {
+	if len(tasks) == 0 {
+		return
+	}
+
+	log.Println("Returning", len(tasks), "tasks to dispatcher")
+
+	data, err := json.Marshal(&WorkRequest{
+		Instance: c.instanceID,
+		Returned: tasks,
+	})
+	if err != nil {
+		log.Println("ERROR: failed to encode returned tasks:", err.Error())
+		return
+	}
+
+	_, err1 := c.client.Request(c.cfg.NATS.Dispatcher.Subject, data, requeueTimeout)
+	if err1 == nil {
+		return
+	}
+
+	log.Println("ERROR: failed to return tasks to dispatcher:", err1.Error())
+
+	for _, taskData := range tasks {
+		t, err2 := c.decodeTask(taskData)
+		if err2 != nil {
+			log.Println("ERROR: dispatcher sent invalid task:", err2.Error())
+			continue
+		}
+
+		c.setStatus(t, StatusQueued)
+		c.enqueue(t)
+	}
+}
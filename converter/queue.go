@@ -0,0 +1,143 @@
+package converter
+
+import (
+	// stdlib
+	"container/heap"
+	"time"
+)
+
+// taskQueue is a priority queue of tasks. Tasks with higher priority
+// are taken first, tasks with same priority are taken in FIFO order.
+//
+// To avoid starvation of low-priority tasks they're aged: every
+// agingInterval spent in queue raises task's effective priority by one.
+// As every queued task ages with the same speed, effective priorities
+// difference between two tasks never changes, so tasks are ordered by
+// static score which is calculated once on push.
+//
+// taskQueue isn't goroutine-safe.
+type taskQueue struct {
+	items         queueItems
+	sequence      uint64
+	createdAt     time.Time
+	agingInterval time.Duration
+}
+
+// Single queued task.
+type queueItem struct {
+	task *Task
+	// Priority with aging applied, higher is better.
+	score float64
+	// Order of pushing for FIFO within same score.
+	sequence uint64
+	// Position in heap, maintained by heap.Interface methods.
+	index int
+}
+
+// Returns true if item should be taken before another one.
+func (qi *queueItem) before(another *queueItem) bool {
+	if qi.score != another.score {
+		return qi.score > another.score
+	}
+
+	return qi.sequence < another.sequence
+}
+
+func newTaskQueue(agingInterval time.Duration) *taskQueue {
+	return &taskQueue{
+		items:         make(queueItems, 0, 64),
+		createdAt:     time.Now(),
+		agingInterval: agingInterval,
+	}
+}
+
+// Len returns queued tasks count.
+func (tq *taskQueue) Len() int {
+	return len(tq.items)
+}
+
+// Push adds task to queue and returns it's position in queue starting
+// from 1.
+func (tq *taskQueue) Push(t *Task) int {
+	tq.sequence++
+	item := &queueItem{
+		task:     t,
+		score:    float64(t.Priority),
+		sequence: tq.sequence,
+	}
+
+	if tq.agingInterval > 0 {
+		item.score -= float64(time.Since(tq.createdAt)) / float64(tq.agingInterval)
+	}
+
+	heap.Push(&tq.items, item)
+
+	return tq.position(item)
+}
+
+// Pop removes task that should be launched next from queue. Returns
+// nil if queue is empty.
+func (tq *taskQueue) Pop() *Task {
+	if len(tq.items) == 0 {
+		return nil
+	}
+
+	return heap.Pop(&tq.items).(*queueItem).task
+}
+
+// Remove removes task with passed ID from queue. Returns nil if there
+// is no such task.
+func (tq *taskQueue) Remove(taskID string) *Task {
+	for _, item := range tq.items {
+		if item.task.ID == taskID {
+			heap.Remove(&tq.items, item.index)
+			return item.task
+		}
+	}
+
+	return nil
+}
+
+// Returns position of queued item starting from 1.
+func (tq *taskQueue) position(item *queueItem) int {
+	position := 1
+	for _, another := range tq.items {
+		if another.before(item) {
+			position++
+		}
+	}
+
+	return position
+}
+
+// queueItems implements heap.Interface.
+type queueItems []*queueItem
+
+func (qi queueItems) Len() int {
+	return len(qi)
+}
+
+func (qi queueItems) Less(i, j int) bool {
+	return qi[i].before(qi[j])
+}
+
+func (qi queueItems) Swap(i, j int) {
+	qi[i], qi[j] = qi[j], qi[i]
+	qi[i].index = i
+	qi[j].index = j
+}
+
+func (qi *queueItems) Push(x interface{}) {
+	item := x.(*queueItem)
+	item.index = len(*qi)
+	*qi = append(*qi, item)
+}
+
+func (qi *queueItems) Pop() interface{} {
+	old := *qi
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*qi = old[:len(old)-1]
+
+	return item
+}
@@ -0,0 +1,59 @@
+package converter
+
+import (
+	// stdlib
+	"testing"
+	"time"
+
+	// other
+	"github.com/stretchr/testify/require"
+)
+
+func popAll(tq *taskQueue) []string {
+	ids := make([]string, 0, tq.Len())
+	for tq.Len() > 0 {
+		ids = append(ids, tq.Pop().ID)
+	}
+
+	return ids
+}
+
+func TestTaskQueuePriorities(t *testing.T) {
+	tq := newTaskQueue(0)
+	require.Nil(t, tq.Pop())
+
+	require.Equal(t, 1, tq.Push(&Task{ID: "low1", Priority: -1}))
+	require.Equal(t, 1, tq.Push(&Task{ID: "normal1"}))
+	require.Equal(t, 1, tq.Push(&Task{ID: "high1", Priority: 10}))
+	require.Equal(t, 3, tq.Push(&Task{ID: "normal2"}))
+	require.Equal(t, 2, tq.Push(&Task{ID: "high2", Priority: 10}))
+	require.Equal(t, 6, tq.Push(&Task{ID: "low2", Priority: -1}))
+
+	require.Equal(t, []string{"high1", "high2", "normal1", "normal2", "low1", "low2"}, popAll(tq))
+}
+
+func TestTaskQueueAging(t *testing.T) {
+	tq := newTaskQueue(time.Minute)
+
+	// Low priority task waits for 3 minutes already.
+	tq.createdAt = time.Now().Add(time.Minute * 3)
+	tq.Push(&Task{ID: "old-low"})
+	tq.createdAt = tq.createdAt.Add(-time.Minute * 3)
+
+	tq.Push(&Task{ID: "normal", Priority: 2})
+	tq.Push(&Task{ID: "high", Priority: 5})
+
+	require.Equal(t, []string{"high", "old-low", "normal"}, popAll(tq))
+}
+
+func TestTaskQueueRemove(t *testing.T) {
+	tq := newTaskQueue(time.Minute)
+	for _, id := range []string{"first", "second", "third", "fourth"} {
+		tq.Push(&Task{ID: id})
+	}
+
+	require.Nil(t, tq.Remove("nonexistent"))
+	require.Equal(t, "third", tq.Remove("third").ID)
+	require.Equal(t, "first", tq.Remove("first").ID)
+	require.Equal(t, []string{"second", "fourth"}, popAll(tq))
+}
@@ -0,0 +1,52 @@
+package converter
+
+import (
+	// stdlib
+	"encoding/json"
+	"log"
+)
+
+const (
+	// How many ffmpeg's stderr lines will be included in result.
+	stderrTailLines = 20
+)
+
+// Result represents task result which is published to NATS when
+// conversion finishes.
+type Result struct {
+	TaskID string
+	Status string
+	// Why task failed or was cancelled, nil if it succeeded.
+	Error *TaskError
+	// Attempt number starting from 1. 0 if task wasn't launched.
+	Attempt int
+	// ffmpeg's exit code. -1 if ffmpeg was killed or wasn't started.
+	ExitCode int
+	// Last lines of ffmpeg's stderr.
+	StderrTail []string
+	// Output file size in bytes.
+	OutputSize int64
+	// Input media duration in seconds, if it was detected.
+	Duration float64
+	// Conversion wall time in seconds.
+	WallTime float64
+	// ID assigned to task by another ffmpeger instance which accepted
+	// it. Set only for "requeued" status.
+	RequeuedAs string
+}
+
+// Publishes result to NATS.
+func (c *Converter) publishResult(result *Result) {
+	log.Printf("Task %s finished with status %s (exit code %d)\n", result.TaskID, result.Status, result.ExitCode)
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		log.Println("ERROR: failed to encode task result:", err.Error())
+		return
+	}
+
+	err1 := c.client.Publish(c.client.Subjects().Results, data)
+	if err1 != nil {
+		log.Println("ERROR: failed to publish task result:", err1.Error())
+	}
+}
@@ -0,0 +1,202 @@
+package converter
+
+import (
+	// stdlib
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	// local
+	"github.com/pztrn/ffmpeger/config"
+)
+
+// RetryPolicy overrides configured retry policy for single task. Zero
+// values means "not set".
+type RetryPolicy struct {
+	// How many times task will be launched, including first attempt.
+	MaxAttempts int
+	// Delay before first retry and maximum delay, in seconds.
+	BackoffBase float64
+	BackoffCap  float64
+	// Classes of errors which are worth retrying, see Error* constants.
+	// Empty list can't be distinguished from "not set", so retries are
+	// disabled with MaxAttempts set to 1.
+	RetryableErrors []string
+}
+
+// Validates retry policy overrides.
+func (p *RetryPolicy) validate() error {
+	if p.MaxAttempts < 0 || p.BackoffBase < 0 || p.BackoffCap < 0 {
+		return errors.New("max attempts and backoff can't be negative")
+	}
+
+	if p.BackoffBase != 0 && p.BackoffCap != 0 && p.BackoffCap < p.BackoffBase {
+		return errors.New("backoff cap can't be less than backoff base")
+	}
+
+	return validateRetryableErrors(p.RetryableErrors)
+}
+
+// Checks that tasks failed with passed error classes can be retried.
+// Invalid and cancelled tasks are never retried.
+func validateRetryableErrors(classes []string) error {
+	for _, class := range classes {
+		switch class {
+		case ErrorInput, ErrorStart, ErrorEncode, ErrorTimeout, ErrorVerification:
+		default:
+			return errors.New("error class '" + class + "' can't be retried")
+		}
+	}
+
+	return nil
+}
+
+// Task waiting for retry.
+type retryingTask struct {
+	task  *Task
+	timer *time.Timer
+}
+
+// Returns retry policy for task, which is configured policy with task's
+// overrides applied.
+func (c *Converter) retryPolicy(t *Task) config.Retry {
+	policy := c.cfg.Retry
+	if t.Retry == nil {
+		return policy
+	}
+
+	if t.Retry.MaxAttempts != 0 {
+		policy.MaxAttempts = t.Retry.MaxAttempts
+	}
+
+	if t.Retry.BackoffBase != 0 {
+		policy.BackoffBase = time.Duration(t.Retry.BackoffBase * float64(time.Second))
+	}
+
+	if t.Retry.BackoffCap != 0 {
+		policy.BackoffCap = time.Duration(t.Retry.BackoffCap * float64(time.Second))
+	}
+
+	if len(t.Retry.RetryableErrors) != 0 {
+		policy.RetryableErrors = t.Retry.RetryableErrors
+	}
+
+	return policy
+}
+
+// Returns true if task which failed with passed error after passed
+// attempts should be launched again.
+func shouldRetry(policy config.Retry, attempts int, taskErr *TaskError) bool {
+	if attempts >= policy.MaxAttempts {
+		return false
+	}
+
+	for _, class := range policy.RetryableErrors {
+		if class == taskErr.Class {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns delay before retry of task which failed passed attempts
+// times. Delay doubles with every attempt.
+func retryDelay(policy config.Retry, attempts int) time.Duration {
+	delay := policy.BackoffBase
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= policy.BackoffCap {
+			return policy.BackoffCap
+		}
+	}
+
+	if delay > policy.BackoffCap {
+		return policy.BackoffCap
+	}
+
+	return delay
+}
+
+// Returns failed task to queue after delay.
+func (c *Converter) scheduleRetry(t *Task, delay time.Duration) {
+	log.Println("Task", t.ID, "will be retried in", delay)
+
+	// Timer can't fire before task is added because removing requires
+	// same lock.
+	c.retryingMutex.Lock()
+	defer c.retryingMutex.Unlock()
+
+	c.retrying[t.ID] = &retryingTask{
+		task:  t,
+		timer: time.AfterFunc(delay, func() { c.retry(t) }),
+	}
+}
+
+// Returns task waiting for retry to queue. Queue lock is held all the
+// time so cancellation will always find task either waiting for retry
+// or in queue.
+func (c *Converter) retry(t *Task) {
+	c.tasksMutex.Lock()
+
+	// Task might be cancelled or taken by shutdown already.
+	if c.removeRetrying(t.ID) == nil {
+		c.tasksMutex.Unlock()
+		return
+	}
+
+	c.setStatus(t, StatusQueued)
+	c.queue.Push(t)
+	c.tasksMutex.Unlock()
+
+	c.tasksAvailable.Broadcast()
+}
+
+// Removes task with passed ID from tasks waiting for retry. Returns nil
+// if there is no such task.
+func (c *Converter) removeRetrying(taskID string) *Task {
+	c.retryingMutex.Lock()
+	defer c.retryingMutex.Unlock()
+
+	rt, found := c.retrying[taskID]
+	if !found {
+		return nil
+	}
+
+	rt.timer.Stop()
+	delete(c.retrying, taskID)
+
+	return rt.task
+}
+
+// Removes all tasks waiting for retry and returns them.
+func (c *Converter) takeRetrying() []*Task {
+	c.retryingMutex.Lock()
+	defer c.retryingMutex.Unlock()
+
+	tasks := make([]*Task, 0, len(c.retrying))
+	for id, rt := range c.retrying {
+		rt.timer.Stop()
+		delete(c.retrying, id)
+		tasks = append(tasks, rt.task)
+	}
+
+	return tasks
+}
+
+// Publishes task that failed for good to dead letter subject. Task
+// contains it's error and attempts count, and can be submitted again
+// as is.
+func (c *Converter) deadLetter(t *Task) {
+	data, err := json.Marshal(t)
+	if err != nil {
+		log.Println("ERROR: failed to encode dead letter task:", err.Error())
+		return
+	}
+
+	err1 := c.client.Publish(c.client.Subjects().DeadLetter, data)
+	if err1 != nil {
+		log.Println("ERROR: failed to publish task", t.ID, "to dead letter subject:", err1.Error())
+	}
+}
@@ -0,0 +1,140 @@
+package converter
+
+import (
+	// stdlib
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	// local
+	"github.com/pztrn/ffmpeger/config"
+
+	// other
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryDelay(t *testing.T) {
+	policy := config.Retry{BackoffBase: time.Second * 30, BackoffCap: time.Minute * 30}
+
+	require.Equal(t, time.Second*30, retryDelay(policy, 1))
+	require.Equal(t, time.Minute, retryDelay(policy, 2))
+	require.Equal(t, time.Minute*4, retryDelay(policy, 4))
+	require.Equal(t, time.Minute*30, retryDelay(policy, 10))
+
+	policy.BackoffBase = time.Hour
+	require.Equal(t, time.Minute*30, retryDelay(policy, 1))
+}
+
+func TestShouldRetry(t *testing.T) {
+	policy := config.Retry{MaxAttempts: 3, RetryableErrors: []string{ErrorEncode}}
+
+	tests := []struct {
+		name     string
+		attempts int
+		class    string
+		retry    bool
+	}{
+		{name: "retryable", attempts: 1, class: ErrorEncode, retry: true},
+		{name: "last retry", attempts: 2, class: ErrorEncode, retry: true},
+		{name: "exhausted", attempts: 3, class: ErrorEncode, retry: false},
+		{name: "not retryable", attempts: 1, class: ErrorStart, retry: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.retry, shouldRetry(policy, test.attempts, &TaskError{Class: test.class}))
+		})
+	}
+}
+
+func TestRetryPolicyOverrides(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Retry = config.Retry{MaxAttempts: 3, BackoffBase: time.Second, BackoffCap: time.Minute, RetryableErrors: []string{ErrorEncode}}
+	c := &Converter{cfg: cfg}
+
+	require.Equal(t, cfg.Retry, c.retryPolicy(&Task{}))
+
+	policy := c.retryPolicy(&Task{Retry: &RetryPolicy{MaxAttempts: 5, BackoffBase: 0.5, RetryableErrors: []string{ErrorInput}}})
+	require.Equal(t, 5, policy.MaxAttempts)
+	require.Equal(t, time.Millisecond*500, policy.BackoffBase)
+	require.Equal(t, time.Minute, policy.BackoffCap)
+	require.Equal(t, []string{ErrorInput}, policy.RetryableErrors)
+
+	for _, bad := range []*RetryPolicy{
+		{MaxAttempts: -1},
+		{BackoffBase: 10, BackoffCap: 5},
+		{RetryableErrors: []string{ErrorCancelled}},
+	} {
+		require.NotNil(t, bad.validate())
+	}
+}
+
+func TestFailedTaskIsRetried(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ffmpeger-test-retry")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	fixture, err1 := filepath.Abs(filepath.Join("testdata", "ffprobe_video.json"))
+	require.Nil(t, err1)
+
+	cfg := &config.Config{}
+	cfg.Retry = config.Retry{MaxAttempts: 2, BackoffBase: time.Millisecond, BackoffCap: time.Millisecond, RetryableErrors: []string{ErrorEncode}}
+	c := newTestConverter(t, cfg, 1)
+	c.ffprobePath = writeFakeBinary(t, dir, "ffprobe", "cat "+fixture)
+	c.ffmpegPath = writeFakeBinary(t, dir, "ffmpeg", "exit 1")
+
+	task := &Task{InputFile: "/tmp/in.mkv", OutputFile: filepath.Join(dir, "out.mp4")}
+	c.AddTask(task)
+
+	require.NotNil(t, c.convert(c.nextTask()))
+	require.Equal(t, StatusRetrying, task.Status)
+	require.Equal(t, 1, task.Attempts)
+
+	// Task returns to queue after backoff.
+	retried := c.nextTask()
+	require.Equal(t, task, retried)
+
+	require.NotNil(t, c.convert(retried))
+	require.Equal(t, StatusFailed, task.Status)
+	require.Equal(t, 2, task.Attempts)
+	require.Equal(t, 0, c.queue.Len())
+}
+
+func TestCancelRetryingTask(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Retry = config.Retry{MaxAttempts: 2, BackoffBase: time.Hour, BackoffCap: time.Hour}
+	c := newTestConverter(t, cfg, 1)
+
+	task := &Task{ID: "retrying", InputFile: "/tmp/in.mkv", OutputFile: "/tmp/out.mp4", Attempts: 1}
+	c.scheduleRetry(task, time.Hour)
+
+	reply := sendControlCommand(t, c, `{"Command": "cancel", "TaskID": "retrying"}`)
+	require.True(t, reply.Success)
+	require.Equal(t, StatusCancelled, task.Status)
+	require.Empty(t, c.retrying)
+	require.Nil(t, c.removeRetrying("retrying"))
+}
+
+func TestCancelledBeforeLaunchTaskIsNotRetried(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Retry = config.Retry{MaxAttempts: 3, BackoffBase: time.Hour, BackoffCap: time.Hour, RetryableErrors: []string{ErrorInput}}
+	c := newTestConverter(t, cfg, 1)
+	c.ffprobePath = "/nonexistent/ffprobe"
+
+	task := &Task{ID: "task", InputFile: "/tmp/in.mkv", OutputFile: "/tmp/out.mp4"}
+	c.AddTask(task)
+	running := c.nextTask()
+
+	// Cancellation arrives while input is probed.
+	reply := sendControlCommand(t, c, `{"Command": "cancel", "TaskID": "task"}`)
+	require.True(t, reply.Success)
+
+	require.NotNil(t, c.convert(running))
+	require.Equal(t, StatusCancelled, task.Status)
+	require.Equal(t, ErrorCancelled, task.Error.Class)
+	require.Empty(t, c.retrying)
+}
@@ -0,0 +1,290 @@
+package converter
+
+import (
+	// stdlib
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+)
+
+// How long re-queued task waits for acknowledgement from another
+// ffmpeger instance.
+const requeueTimeout = time.Second * 5
+
+// Adds task to queue and wakes up waiting workers. Returns
+// task's position in queue.
+func (c *Converter) enqueue(t *Task) int {
+	c.tasksMutex.Lock()
+	position := c.queue.Push(t)
+	c.tasksMutex.Unlock()
+
+	// JetStream fetcher waits for same condition, so everyone should
+	// be woken up.
+	c.tasksAvailable.Broadcast()
+
+	return position
+}
+
+// Queues received task unless queue is full or converter is shutting
+// down. Returns task's position in queue.
+func (c *Converter) tryEnqueue(t *Task) (int, error) {
+	c.tasksMutex.Lock()
+	// Handler might be still running when shutdown takes remaining
+	// tasks, so task wouldn't be handled.
+	if c.shouldShutdown {
+		c.tasksMutex.Unlock()
+		return 0, errors.New("shutting down")
+	}
+
+	capacity := c.cfg.Queue.Capacity
+	if capacity > 0 && c.queue.Len() >= capacity {
+		c.tasksMutex.Unlock()
+		return 0, errors.New("queue is full")
+	}
+
+	c.setStatus(t, StatusQueued)
+	position := c.queue.Push(t)
+	c.tasksMutex.Unlock()
+
+	c.tasksAvailable.Broadcast()
+
+	return position, nil
+}
+
+// Waits until there will be free slots for tasks, so we'll fetch only
+// tasks we can launch right away. Returns 0 if converter is shutting
+// down.
+func (c *Converter) waitFreeSlots() int {
+	c.tasksMutex.Lock()
+	defer c.tasksMutex.Unlock()
+
+	for !c.shouldShutdown {
+		c.runningTasksMutex.Lock()
+		running := len(c.runningTasks)
+		c.runningTasksMutex.Unlock()
+
+		free := c.maximumConcurrentTasks - running - c.queue.Len()
+		if free > 0 {
+			return free
+		}
+
+		c.tasksAvailable.Wait()
+	}
+
+	return 0
+}
+
+// Waits until there will be a task to launch. Returned task is already
+// marked as running, so cancellation will always find it either in queue
+// or in running tasks. Returns nil if converter is shutting down.
+func (c *Converter) nextTask() *Task {
+	c.tasksMutex.Lock()
+	defer c.tasksMutex.Unlock()
+
+	for c.queue.Len() == 0 && !c.shouldShutdown {
+		c.tasksAvailable.Wait()
+	}
+
+	if c.shouldShutdown {
+		return nil
+	}
+
+	t := c.queue.Pop()
+	c.markRunning(t)
+
+	return t
+}
+
+// Starts workers pool. Every worker launches one task at a time, so
+// there will be no more than maximumConcurrentTasks running tasks.
+func (c *Converter) startWorkers() {
+	c.workersStopped = make(chan struct{})
+
+	for i := 0; i < c.maximumConcurrentTasks; i++ {
+		c.workers.Add(1)
+		go c.worker(i)
+	}
+
+	if c.cfg.NATS.JetStream.Enabled {
+		c.workers.Add(1)
+		go c.fetchTasks()
+	}
+
+	if c.cfg.NATS.Dispatcher.Subject != "" {
+		c.workers.Add(1)
+		go c.pullTasks()
+	}
+
+	go func() {
+		c.workers.Wait()
+		close(c.workersStopped)
+	}()
+}
+
+// Worker takes tasks from queue and launches them until shutdown.
+func (c *Converter) worker(id int) {
+	defer c.workers.Done()
+
+	log.Println("Converter worker #" + strconv.Itoa(id) + " started")
+
+	for {
+		t := c.nextTask()
+		if t == nil {
+			break
+		}
+
+		stopReporting := c.reportInProgress(t)
+		err := c.runTask(t)
+		if err != nil {
+			log.Println("ERROR: task", t.ID, "failed:", err.Error())
+		}
+		stopReporting()
+		c.acknowledge(t)
+
+		// Slot is free now.
+		c.tasksAvailable.Broadcast()
+	}
+
+	log.Println("Converter worker #" + strconv.Itoa(id) + " stopped")
+}
+
+// Signals workers to stop taking tasks from queue. Running tasks will
+// continue to run.
+func (c *Converter) stopWorkers() {
+	c.tasksMutex.Lock()
+	if !c.shouldShutdown {
+		c.shouldShutdown = true
+		close(c.stopRequested)
+	}
+	c.tasksMutex.Unlock()
+
+	c.tasksAvailable.Broadcast()
+}
+
+// Kills ffmpeg of every running task. Killed tasks are returned to
+// queue.
+func (c *Converter) killRunningTasks() {
+	c.tasksMutex.Lock()
+	defer c.tasksMutex.Unlock()
+
+	select {
+	case <-c.shutdownRequested:
+		// Already killed.
+	default:
+		close(c.shutdownRequested)
+	}
+}
+
+// Waits until all workers will stop. Returns false if timeout passed
+// before that.
+func (c *Converter) waitWorkers(timeout time.Duration) bool {
+	select {
+	case <-c.workersStopped:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Takes care of tasks left in queue or waiting for retry after workers
+// was stopped. They're
+// either left in persistent store to be re-queued on next start or
+// published back to tasks topic for other ffmpeger instances.
+func (c *Converter) requeueRemaining() {
+	c.tasksMutex.Lock()
+	remaining := make([]*Task, 0, c.queue.Len())
+	for c.queue.Len() > 0 {
+		remaining = append(remaining, c.queue.Pop())
+	}
+	remaining = append(remaining, c.takeRetrying()...)
+	c.tasksMutex.Unlock()
+
+	// JetStream will redeliver tasks received from it.
+	fromTopic := remaining[:0]
+	for _, t := range remaining {
+		if t.jetStreamMessage != nil {
+			c.returnToJetStream(t)
+			continue
+		}
+		fromTopic = append(fromTopic, t)
+	}
+	remaining = fromTopic
+
+	if len(remaining) == 0 {
+		return
+	}
+
+	if c.cfg.Shutdown.Requeue == "nats" {
+		log.Println("Sending", len(remaining), "unfinished tasks back to", c.client.Subjects().Submit, "topic...")
+		notRequeued := remaining[:0]
+		for _, t := range remaining {
+			newID, err := c.republish(t)
+			if err != nil {
+				log.Println("ERROR: failed to re-queue task", t.ID+":", err.Error())
+				notRequeued = append(notRequeued, t)
+				continue
+			}
+
+			c.setStatus(t, StatusRequeued)
+			c.publishResult(&Result{
+				TaskID:     t.ID,
+				Status:     StatusRequeued,
+				ExitCode:   -1,
+				RequeuedAs: newID,
+			})
+		}
+
+		// Tasks nobody accepted are handled like with "store".
+		remaining = notRequeued
+		if len(remaining) == 0 {
+			return
+		}
+	}
+
+	if c.store.Persistent() {
+		for _, t := range remaining {
+			c.setStatus(t, StatusQueued)
+		}
+		log.Println(len(remaining), "unfinished tasks will be re-queued on next start")
+		return
+	}
+
+	log.Println("ERROR: tasks store isn't persistent,", len(remaining), "unfinished tasks will be lost")
+	for _, t := range remaining {
+		c.setStatus(t, StatusFailed)
+		c.publishResult(&Result{
+			TaskID:   t.ID,
+			Status:   StatusFailed,
+			ExitCode: -1,
+		})
+	}
+}
+
+// Sends task to tasks topic as request, so we'll know that another
+// ffmpeger instance accepted it. Receiver assigns new ID to task, it's
+// returned.
+func (c *Converter) republish(t *Task) (string, error) {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return "", errors.New("Failed to encode task: " + err.Error())
+	}
+
+	replyData, err1 := c.client.Request(c.client.Subjects().Submit, data, requeueTimeout)
+	if err1 != nil {
+		return "", errors.New("Nobody accepted task: " + err1.Error())
+	}
+
+	ack := &Acknowledgement{}
+	err2 := json.Unmarshal(replyData, ack)
+	if err2 != nil {
+		return "", errors.New("Failed to decode acknowledgement: " + err2.Error())
+	}
+
+	if ack.Status != AckAccepted {
+		return "", errors.New("Task was rejected: " + ack.Reason)
+	}
+
+	return ack.TaskID, nil
+}
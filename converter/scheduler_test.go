@@ -0,0 +1,266 @@
+package converter
+
+import (
+	// stdlib
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	// local
+	"github.com/pztrn/ffmpeger/config"
+	"github.com/pztrn/ffmpeger/nats"
+
+	// other
+	"github.com/stretchr/testify/require"
+)
+
+// Creates NATS client for passed configuration. It isn't connected, so
+// nothing is published.
+func newTestClient(tb testing.TB, cfg *config.Config) *nats.Client {
+	client, err := nats.NewClient(&cfg.NATS)
+	require.Nil(tb, err)
+
+	return client
+}
+
+// Creates converter with memory store and passed workers count.
+func newTestConverter(tb testing.TB, cfg *config.Config, workersCount int) *Converter {
+	c, err := New(cfg, newTestClient(tb, cfg), WithStore(newMemoryStore()), WithMaxConcurrency(workersCount))
+	require.Nil(tb, err)
+
+	return c
+}
+
+// Replaces conversion with passed function and starts workers.
+// Returned function stops them.
+func startTestWorkers(c *Converter, run func(t *Task)) func() {
+	c.runTask = func(t *Task) error {
+		run(t)
+		c.unmarkRunning(t)
+		return nil
+	}
+	c.startWorkers()
+
+	return c.Shutdown
+}
+
+func TestSchedulerStartsTaskImmediately(t *testing.T) {
+	started := make(chan string, 1)
+	c := newTestConverter(t, &config.Config{}, 1)
+	stop := startTestWorkers(c, func(t *Task) {
+		started <- t.ID
+	})
+	defer stop()
+
+	// Workers should be waiting for tasks now.
+	time.Sleep(time.Millisecond * 10)
+
+	c.AddTask(&Task{ID: "task"})
+	select {
+	case id := <-started:
+		require.Equal(t, "task", id)
+	case <-time.After(time.Millisecond * 100):
+		t.Fatal("Task wasn't started in 100ms")
+	}
+}
+
+func TestSchedulerConcurrencyLimit(t *testing.T) {
+	release := make(chan bool)
+	started := make(chan string, 3)
+	c := newTestConverter(t, &config.Config{}, 2)
+	stop := startTestWorkers(c, func(t *Task) {
+		started <- t.ID
+		<-release
+	})
+	defer stop()
+
+	for i := 1; i <= 3; i++ {
+		c.AddTask(&Task{ID: "task" + strconv.Itoa(i)})
+	}
+
+	<-started
+	<-started
+	select {
+	case id := <-started:
+		t.Fatal("Task", id, "was started over concurrency limit")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	// Freed slot should be taken by queued task.
+	release <- true
+	require.Equal(t, "task3", <-started)
+
+	release <- true
+	release <- true
+}
+
+func TestSchedulerShutdownStopsIdleWorkers(t *testing.T) {
+	c := newTestConverter(t, &config.Config{}, 4)
+	stop := startTestWorkers(c, func(t *Task) {})
+
+	done := make(chan bool)
+	go func() {
+		stop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Workers wasn't stopped in 1 second")
+	}
+}
+
+// Emulates conversion which is finished after release or killed on
+// shutdown like real one.
+func releasableTask(c *Converter, started chan string, release chan bool) func(t *Task) {
+	return func(t *Task) {
+		c.setStatus(t, StatusRunning)
+		started <- t.ID
+
+		select {
+		case <-release:
+			c.setStatus(t, StatusSucceeded)
+		case <-c.shutdownRequested:
+			c.setStatus(t, StatusQueued)
+			c.enqueue(t)
+		}
+	}
+}
+
+func TestSchedulerDrainOnShutdown(t *testing.T) {
+	release := make(chan bool)
+	started := make(chan string, 2)
+	cfg := &config.Config{Shutdown: config.Shutdown{Mode: "drain", DrainTimeout: time.Second, Requeue: "store"}}
+	c := newTestConverter(t, cfg, 1)
+	stop := startTestWorkers(c, releasableTask(c, started, release))
+	defer stop()
+
+	running := &Task{ID: "running"}
+	queued := &Task{ID: "queued"}
+	c.AddTask(running)
+	<-started
+	c.AddTask(queued)
+
+	shutdownDone := make(chan bool)
+	go func() {
+		c.Shutdown()
+		close(shutdownDone)
+	}()
+
+	// Running task should be allowed to finish, queued one shouldn't
+	// be started.
+	time.Sleep(time.Millisecond * 50)
+	release <- true
+	<-shutdownDone
+
+	require.Equal(t, StatusSucceeded, running.Status)
+	// Memory store can't keep tasks till next start.
+	require.Equal(t, StatusFailed, queued.Status)
+	require.Len(t, started, 0)
+}
+
+func TestSchedulerDrainTimeout(t *testing.T) {
+	started := make(chan string, 1)
+	cfg := &config.Config{Shutdown: config.Shutdown{Mode: "drain", DrainTimeout: time.Millisecond * 50, Requeue: "store"}}
+	c := newTestConverter(t, cfg, 1)
+	stop := startTestWorkers(c, releasableTask(c, started, make(chan bool)))
+	defer stop()
+
+	running := &Task{ID: "running"}
+	c.AddTask(running)
+	<-started
+
+	startedAt := time.Now()
+	c.Shutdown()
+	require.True(t, time.Since(startedAt) >= time.Millisecond*50)
+	require.Equal(t, StatusFailed, running.Status)
+}
+
+func TestSchedulerKillOnShutdown(t *testing.T) {
+	started := make(chan string, 1)
+	cfg := &config.Config{Shutdown: config.Shutdown{Mode: "kill", DrainTimeout: time.Hour, Requeue: "store"}}
+	c := newTestConverter(t, cfg, 1)
+	stop := startTestWorkers(c, releasableTask(c, started, make(chan bool)))
+	defer stop()
+
+	running := &Task{ID: "running"}
+	c.AddTask(running)
+	<-started
+
+	done := make(chan bool)
+	go func() {
+		c.Shutdown()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Running task wasn't killed")
+	}
+}
+
+// Measures time between task submission and it's start.
+func BenchmarkSchedulerLatency(b *testing.B) {
+	started := make(chan time.Time, 1)
+	c := newTestConverter(b, &config.Config{}, 1)
+	stop := startTestWorkers(c, func(t *Task) {
+		started <- time.Now()
+	})
+	defer stop()
+
+	var total time.Duration
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		submittedAt := time.Now()
+		c.AddTask(&Task{ID: "task" + strconv.Itoa(i)})
+		total += (<-started).Sub(submittedAt)
+	}
+	b.StopTimer()
+
+	b.ReportMetric(float64(total.Nanoseconds())/float64(b.N), "ns/start")
+}
+
+// Memory store which pretends to be persistent.
+type persistentMemoryStore struct {
+	*memoryStore
+}
+
+func (pms *persistentMemoryStore) Persistent() bool {
+	return true
+}
+
+func TestRequeueToNATSWithoutReceivers(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Shutdown.Requeue = "nats"
+	c, err := New(cfg, newTestClient(t, cfg), WithStore(&persistentMemoryStore{newMemoryStore()}))
+	require.Nil(t, err)
+
+	task := &Task{ID: "task", InputFile: "/tmp/in.mkv", OutputFile: "/tmp/out.mp4"}
+	c.AddTask(task)
+
+	// Nobody accepted task, so it should be kept in store for next
+	// start instead of being lost.
+	c.requeueRemaining()
+	require.Equal(t, StatusQueued, task.Status)
+
+	storedTasks, err1 := c.store.List()
+	require.Nil(t, err1)
+	require.Len(t, storedTasks, 1)
+	require.Equal(t, StatusQueued, storedTasks[0].Status)
+}
+
+func TestReturnWorkWithoutDispatcher(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.NATS.Dispatcher.Subject = "dispatcher"
+	c := newTestConverter(t, cfg, 1)
+
+	// Dispatcher can't take tasks back, so they should be queued to be
+	// handled like other tasks left in queue.
+	c.returnWork([]json.RawMessage{
+		json.RawMessage(`{"InputFile": "/tmp/in.mkv", "OutputFile": "/tmp/out.mp4"}`),
+	})
+	require.Equal(t, 1, c.queue.Len())
+}
@@ -0,0 +1,112 @@
+package converter
+
+import (
+	// stdlib
+	"errors"
+	"log"
+
+	// local
+	"github.com/pztrn/ffmpeger/nats"
+
+	// other
+	"github.com/nats-io/nuid"
+)
+
+// Returns handler for tasks topic.
+func (c *Converter) newTasksHandler() *nats.Handler {
+	return &nats.Handler{
+		Name:       "converter",
+		Subject:    nats.SubjectSubmit,
+		New:        newTaskMessage,
+		Func:       c.handleTask,
+		ErrorReply: rejected,
+	}
+}
+
+// How many probe requests are served at once.
+const probeConcurrency = 4
+
+// Returns handler for media inspection requests. ffprobe might hang
+// for a long time on unavailable input, so several probes are served at
+// once.
+func (c *Converter) newProbeHandler() *nats.Handler {
+	return &nats.Handler{
+		Name:        "converter-probe",
+		Subject:     nats.SubjectProbe,
+		New:         newTaskMessage,
+		Func:        c.handleProbe,
+		ErrorReply:  probeErrorReply,
+		Concurrency: probeConcurrency,
+	}
+}
+
+// Returns handler for control topic.
+func (c *Converter) newControlHandler() *nats.Handler {
+	return &nats.Handler{
+		Name:       "converter-control",
+		Subject:    nats.SubjectControl,
+		New:        newControlMessage,
+		Func:       c.handleControlCommand,
+		ErrorReply: controlErrorReply,
+	}
+}
+
+// Returns value task in passed schema version should be decoded into.
+// New task format should get it's own structure here, so senders can
+// migrate to it one by one.
+func newTaskMessage(version int) interface{} {
+	switch version {
+	case nats.SchemaVersion1:
+		return &Task{}
+	}
+
+	return nil
+}
+
+// Returns value control command in passed schema version should be
+// decoded into.
+func newControlMessage(version int) interface{} {
+	switch version {
+	case nats.SchemaVersion1:
+		return &ControlCommand{}
+	}
+
+	return nil
+}
+
+// Decodes and validates task received not via tasks handler (e.g. from
+// JetStream). Task gets new ID.
+func (c *Converter) decodeTask(data []byte) (*Task, error) {
+	msg, _, _, err := nats.Decode(data, newTaskMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.acceptTask(msg)
+}
+
+// Converts decoded task message into task and validates it. Task gets
+// new ID.
+func (c *Converter) acceptTask(msg interface{}) (*Task, error) {
+	var t *Task
+	switch m := msg.(type) {
+	case *Task:
+		t = m
+	default:
+		return nil, errors.New("unexpected task message")
+	}
+
+	// Task ID, status, error and attempts are always assigned by us.
+	t.ID = nuid.Next()
+	t.Status = ""
+	t.Error = nil
+	t.Attempts = 0
+	log.Printf("Received task: %+v\n", t)
+
+	err := t.validate(c.cfg.Profiles)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
@@ -0,0 +1,55 @@
+package converter
+
+const (
+	// Longer stderr lines are truncated.
+	maxStderrLineLength = 1024
+)
+
+// stderrRing keeps last lines of ffmpeg's stderr for diagnostics. Old
+// lines are overwritten, so memory usage doesn't depend on how much
+// ffmpeg writes.
+//
+// stderrRing isn't goroutine-safe.
+type stderrRing struct {
+	lines []string
+	// Where next line will be written.
+	next int
+	// Indicates that buffer was filled at least once.
+	full bool
+}
+
+func newStderrRing(size int) *stderrRing {
+	return &stderrRing{
+		lines: make([]string, size),
+	}
+}
+
+// Add remembers line, overwriting the oldest one if buffer is full.
+func (r *stderrRing) Add(line string) {
+	if len(r.lines) == 0 {
+		return
+	}
+
+	if len(line) > maxStderrLineLength {
+		line = line[:maxStderrLineLength] + "..."
+	}
+
+	r.lines[r.next] = line
+	r.next++
+	if r.next == len(r.lines) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// Lines returns remembered lines from oldest to newest.
+func (r *stderrRing) Lines() []string {
+	if !r.full {
+		return append([]string(nil), r.lines[:r.next]...)
+	}
+
+	lines := make([]string, 0, len(r.lines))
+	lines = append(lines, r.lines[r.next:]...)
+
+	return append(lines, r.lines[:r.next]...)
+}
@@ -0,0 +1,45 @@
+package converter
+
+import (
+	// stdlib
+	"strings"
+	"testing"
+
+	// other
+	"github.com/stretchr/testify/require"
+)
+
+func TestStderrRing(t *testing.T) {
+	tests := []struct {
+		name  string
+		size  int
+		lines []string
+		want  []string
+	}{
+		{name: "empty", size: 3, want: []string{}},
+		{name: "not full", size: 3, lines: []string{"a", "b"}, want: []string{"a", "b"}},
+		{name: "full", size: 3, lines: []string{"a", "b", "c"}, want: []string{"a", "b", "c"}},
+		{name: "wrapped", size: 3, lines: []string{"a", "b", "c", "d", "e"}, want: []string{"c", "d", "e"}},
+		{name: "zero size", size: 0, lines: []string{"a"}, want: []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newStderrRing(test.size)
+			for _, line := range test.lines {
+				r.Add(line)
+			}
+
+			require.Equal(t, test.want, append([]string{}, r.Lines()...))
+		})
+	}
+}
+
+func TestStderrRingTruncatesLongLines(t *testing.T) {
+	r := newStderrRing(1)
+	r.Add(strings.Repeat("x", maxStderrLineLength*2))
+
+	lines := r.Lines()
+	require.Len(t, lines, 1)
+	require.Equal(t, strings.Repeat("x", maxStderrLineLength)+"...", lines[0])
+}
@@ -0,0 +1,344 @@
+package converter
+
+import (
+	// stdlib
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	// local
+	"github.com/pztrn/ffmpeger/config"
+)
+
+// Store is an interface for tasks persistence. Store keeps tasks
+// snapshots, so tasks should be saved every time their state changes.
+// Save is called with converter's locks held, so it should be fast.
+type Store interface {
+	// Save creates or updates task's snapshot.
+	Save(t *Task) error
+	// List returns all stored tasks in order they were first saved.
+	List() ([]*Task, error)
+	// Persistent returns true if tasks will survive restart.
+	Persistent() bool
+	// Close persists everything saved before. It's called when
+	// converter shutdown is completed.
+	Close() error
+}
+
+// Creates store configured in configuration file.
+func newStore(cfg *config.Queue) (Store, error) {
+	switch cfg.Store {
+	case "", "memory":
+		return newMemoryStore(), nil
+	case "file":
+		return newFileStore(cfg.Path, cfg.KeepFinished)
+	}
+
+	return nil, errors.New("unknown store type '" + cfg.Store + "'")
+}
+
+// snapshots holds tasks snapshots in order they were first saved. It
+// is a base for stores, stores decide what to do with snapshots.
+type snapshots struct {
+	keepFinished int
+
+	order    []string
+	tasks    map[string][]byte
+	statuses map[string]string
+}
+
+func newSnapshots(keepFinished int) *snapshots {
+	return &snapshots{
+		keepFinished: keepFinished,
+		order:        make([]string, 0, 64),
+		tasks:        make(map[string][]byte),
+		statuses:     make(map[string]string),
+	}
+}
+
+// Saves snapshot and forgets oldest finished tasks if there are too
+// many of them.
+func (s *snapshots) save(id string, status string, data []byte) {
+	_, exists := s.tasks[id]
+	if !exists {
+		s.order = append(s.order, id)
+	}
+
+	s.tasks[id] = data
+	s.statuses[id] = status
+
+	if !isFinalStatus(status) {
+		return
+	}
+
+	finished := 0
+	for _, taskID := range s.order {
+		if isFinalStatus(s.statuses[taskID]) {
+			finished++
+		}
+	}
+
+	order := make([]string, 0, len(s.order))
+	for _, taskID := range s.order {
+		if finished > s.keepFinished && isFinalStatus(s.statuses[taskID]) {
+			delete(s.tasks, taskID)
+			delete(s.statuses, taskID)
+			finished--
+			continue
+		}
+		order = append(order, taskID)
+	}
+	s.order = order
+}
+
+// Decodes all snapshots.
+func (s *snapshots) list() ([]*Task, error) {
+	tasksList := make([]*Task, 0, len(s.order))
+	for _, id := range s.order {
+		t := &Task{}
+		err := json.Unmarshal(s.tasks[id], t)
+		if err != nil {
+			return nil, errors.New("failed to decode task " + id + ": " + err.Error())
+		}
+		tasksList = append(tasksList, t)
+	}
+
+	return tasksList, nil
+}
+
+// memoryStore keeps tasks in memory. It is used when no persistence
+// was configured.
+type memoryStore struct {
+	snapshots *snapshots
+	mutex     sync.Mutex
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		snapshots: newSnapshots(100),
+	}
+}
+
+// Save creates or updates task's snapshot.
+func (ms *memoryStore) Save(t *Task) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return errors.New("failed to encode task: " + err.Error())
+	}
+
+	ms.mutex.Lock()
+	ms.snapshots.save(t.ID, t.Status, data)
+	ms.mutex.Unlock()
+
+	return nil
+}
+
+// List returns all stored tasks in order they were first saved.
+func (ms *memoryStore) List() ([]*Task, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	return ms.snapshots.list()
+}
+
+// Persistent returns false as everything is lost on restart.
+func (ms *memoryStore) Persistent() bool {
+	return false
+}
+
+// Close does nothing as there is nothing to persist.
+func (ms *memoryStore) Close() error {
+	return nil
+}
+
+// fileStore keeps tasks in single JSON file. Whole file is rewritten
+// in background after saves, saves made while file is written are
+// written at once. File is replaced atomically so it won't be corrupted
+// on crash, but last saves might be lost.
+type fileStore struct {
+	path      string
+	snapshots *snapshots
+	// Signals flusher that snapshots were changed. Closed by Close.
+	changed chan struct{}
+	// Closed when flusher wrote last changes.
+	flushed chan struct{}
+	closed  bool
+	// Last write error, returned by Close.
+	flushErr error
+	// Protects snapshots, closed flag and last write error.
+	mutex sync.Mutex
+	// Makes sure that file is written by one goroutine at a time.
+	writeMutex sync.Mutex
+}
+
+func newFileStore(path string, keepFinished int) (*fileStore, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, errors.New("failed to get absolute path for store file: " + err.Error())
+	}
+
+	fs := &fileStore{
+		path:      absPath,
+		snapshots: newSnapshots(keepFinished),
+		changed:   make(chan struct{}, 1),
+		flushed:   make(chan struct{}),
+	}
+
+	data, err1 := ioutil.ReadFile(fs.path)
+	if err1 != nil {
+		if os.IsNotExist(err1) {
+			go fs.flusher()
+			return fs, nil
+		}
+		return nil, errors.New("failed to read store file: " + err1.Error())
+	}
+
+	stored := make([]json.RawMessage, 0, 64)
+	err2 := json.Unmarshal(data, &stored)
+	if err2 != nil {
+		return nil, errors.New("failed to parse store file: " + err2.Error())
+	}
+
+	for _, data := range stored {
+		t := &Task{}
+		err3 := json.Unmarshal(data, t)
+		if err3 != nil {
+			return nil, errors.New("failed to decode stored task: " + err3.Error())
+		}
+		fs.snapshots.save(t.ID, t.Status, data)
+	}
+
+	go fs.flusher()
+
+	return fs, nil
+}
+
+// Save creates or updates task's snapshot. Snapshots are written to
+// file in background, or immediately if store was closed.
+func (fs *fileStore) Save(t *Task) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return errors.New("failed to encode task: " + err.Error())
+	}
+
+	fs.mutex.Lock()
+	fs.snapshots.save(t.ID, t.Status, data)
+	closed := fs.closed
+	if !closed {
+		select {
+		case fs.changed <- struct{}{}:
+		default:
+			// Flusher will write this change with pending one.
+		}
+	}
+	fs.mutex.Unlock()
+
+	if closed {
+		return fs.flush()
+	}
+
+	return nil
+}
+
+// List returns all stored tasks in order they were first saved.
+func (fs *fileStore) List() ([]*Task, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	return fs.snapshots.list()
+}
+
+// Persistent returns true as tasks are kept in file.
+func (fs *fileStore) Persistent() bool {
+	return true
+}
+
+// Close waits until flusher will write all saved snapshots and stops
+// it. Returns last write error.
+func (fs *fileStore) Close() error {
+	fs.mutex.Lock()
+	if !fs.closed {
+		fs.closed = true
+		close(fs.changed)
+	}
+	fs.mutex.Unlock()
+
+	<-fs.flushed
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	return fs.flushErr
+}
+
+// Writes snapshots to file every time they're changed until store is
+// closed.
+func (fs *fileStore) flusher() {
+	defer close(fs.flushed)
+
+	for range fs.changed {
+		err := fs.flush()
+		if err != nil {
+			log.Println("ERROR: failed to write tasks store:", err.Error())
+		}
+
+		fs.mutex.Lock()
+		fs.flushErr = err
+		fs.mutex.Unlock()
+	}
+}
+
+// Writes snapshots to temporary file and replaces store file with it.
+func (fs *fileStore) flush() error {
+	fs.writeMutex.Lock()
+	defer fs.writeMutex.Unlock()
+
+	// Snapshots are encoded after write lock is taken, so the last
+	// write always has the latest snapshots.
+	fs.mutex.Lock()
+	stored := make([]json.RawMessage, 0, len(fs.snapshots.order))
+	for _, id := range fs.snapshots.order {
+		stored = append(stored, fs.snapshots.tasks[id])
+	}
+
+	data, err := json.Marshal(stored)
+	fs.mutex.Unlock()
+	if err != nil {
+		return errors.New("failed to encode tasks: " + err.Error())
+	}
+
+	err1 := os.MkdirAll(filepath.Dir(fs.path), 0755)
+	if err1 != nil {
+		return errors.New("failed to create store directory: " + err1.Error())
+	}
+
+	tmpFile, err2 := ioutil.TempFile(filepath.Dir(fs.path), filepath.Base(fs.path)+".*")
+	if err2 != nil {
+		return errors.New("failed to create temporary store file: " + err2.Error())
+	}
+
+	_, err3 := tmpFile.Write(data)
+	if err3 == nil {
+		err3 = tmpFile.Sync()
+	}
+	err4 := tmpFile.Close()
+	if err3 == nil {
+		err3 = err4
+	}
+	if err3 != nil {
+		os.Remove(tmpFile.Name())
+		return errors.New("failed to write temporary store file: " + err3.Error())
+	}
+
+	err5 := os.Rename(tmpFile.Name(), fs.path)
+	if err5 != nil {
+		os.Remove(tmpFile.Name())
+		return errors.New("failed to replace store file: " + err5.Error())
+	}
+
+	return nil
+}
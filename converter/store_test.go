@@ -0,0 +1,93 @@
+package converter
+
+import (
+	// stdlib
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	// local
+	"github.com/pztrn/ffmpeger/config"
+
+	// other
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStoreSurvivesReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ffmpeger-test-store")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "data", "queue.json")
+	fs, err1 := newFileStore(path, 1)
+	require.Nil(t, err1)
+	require.True(t, fs.Persistent())
+
+	for i := 1; i <= 4; i++ {
+		task := &Task{ID: "task" + strconv.Itoa(i), InputFile: "/tmp/in.mkv", OutputFile: "/tmp/out.mp4", Status: StatusQueued}
+		require.Nil(t, fs.Save(task))
+	}
+
+	// Finished tasks over keep_finished limit should be forgotten, oldest
+	// first.
+	require.Nil(t, fs.Save(&Task{ID: "task1", Status: StatusSucceeded}))
+	require.Nil(t, fs.Save(&Task{ID: "task2", Status: StatusFailed}))
+	require.Nil(t, fs.Save(&Task{ID: "task3", Status: StatusRunning}))
+	// Everything saved should be written on close.
+	require.Nil(t, fs.Close())
+
+	fs1, err2 := newFileStore(path, 1)
+	require.Nil(t, err2)
+	defer fs1.Close()
+
+	storedTasks, err3 := fs1.List()
+	require.Nil(t, err3)
+	require.Len(t, storedTasks, 3)
+	require.Equal(t, "task2", storedTasks[0].ID)
+	require.Equal(t, StatusFailed, storedTasks[0].Status)
+	require.Equal(t, "task3", storedTasks[1].ID)
+	require.Equal(t, StatusRunning, storedTasks[1].Status)
+	require.Equal(t, "task4", storedTasks[2].ID)
+	require.Equal(t, "/tmp/in.mkv", storedTasks[2].InputFile)
+}
+
+func TestFileStoreCorruptedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ffmpeger-test-store")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "queue.json")
+	err1 := ioutil.WriteFile(path, []byte("[{"), 0644)
+	if err1 != nil {
+		t.Fatal("Failed to write store file:", err1.Error())
+	}
+
+	_, err2 := newFileStore(path, 1)
+	require.NotNil(t, err2)
+}
+
+func TestRestoreTasks(t *testing.T) {
+	c := newTestConverter(t, &config.Config{}, 1)
+
+	require.Nil(t, c.store.Save(&Task{ID: "queued", InputFile: "/tmp/in.mkv", OutputFile: "/tmp/out.mp4", Status: StatusQueued}))
+	require.Nil(t, c.store.Save(&Task{ID: "running", InputFile: "/tmp/in.mkv", OutputFile: "/tmp/out.mp4", Status: StatusRunning}))
+	require.Nil(t, c.store.Save(&Task{ID: "finished", InputFile: "/tmp/in.mkv", OutputFile: "/tmp/out.mp4", Status: StatusSucceeded}))
+	require.Nil(t, c.store.Save(&Task{ID: "invalid", InputFile: "/tmp/in.mkv", OutputFile: "/tmp/out.mp4", Profile: "removed", Status: StatusQueued}))
+
+	require.Nil(t, c.restoreTasks())
+	require.Equal(t, 2, c.queue.Len())
+	require.Equal(t, "queued", c.queue.Pop().ID)
+	running := c.queue.Pop()
+	require.Equal(t, "running", running.ID)
+	require.Equal(t, StatusQueued, running.Status)
+
+	storedTasks, err := c.store.List()
+	require.Nil(t, err)
+	require.Equal(t, StatusFailed, storedTasks[3].Status)
+}
@@ -3,215 +3,504 @@ package converter
 import (
 	// stdlib
 	"bufio"
+	"errors"
 	"log"
 	"os"
 	"os/exec"
-	"strconv"
-	"strings"
 	"time"
+
+	// local
+	"github.com/pztrn/ffmpeger/config"
+	"github.com/pztrn/ffmpeger/nats"
+)
+
+const (
+	// TaskTypeConvert is a type of conversion tasks. It's a default one.
+	TaskTypeConvert = "convert"
+	// TaskTypeProbe is a type of "probe only" tasks. Such tasks aren't
+	// queued, MediaInfo for input file is returned as a reply instead.
+	TaskTypeProbe = "probe"
+)
+
+const (
+	// StatusQueued means that task is waiting in queue.
+	StatusQueued = "queued"
+	// StatusRunning means that task is being converted.
+	StatusRunning = "running"
+	// StatusSucceeded means that ffmpeg exited successfully and output
+	// file was produced.
+	StatusSucceeded = "succeeded"
+	// StatusRetrying means that task failed but will be launched again
+	// after backoff delay.
+	StatusRetrying = "retrying"
+	// StatusFailed means that ffmpeg failed or produced no output and
+	// task won't be retried.
+	StatusFailed = "failed"
+	// StatusCancelled means that task was cancelled via control topic.
+	StatusCancelled = "cancelled"
+	// StatusRequeued means that task was returned to tasks topic or
+	// JetStream on shutdown and will be converted by another ffmpeger
+	// instance.
+	StatusRequeued = "requeued"
 )
 
+// Returns true if task with passed status will never run again.
+func isFinalStatus(status string) bool {
+	return status == StatusSucceeded || status == StatusFailed || status == StatusCancelled || status == StatusRequeued
+}
+
 // Task represents a single task received via NATS.
 type Task struct {
+	// Unique task ID. Assigned when task is received.
+	ID string
+	// Task type, see TaskType* constants. Empty means conversion.
+	Type       string
 	Name       string
 	InputFile  string
 	OutputFile string
+	// Encoding profile name. If empty - default profile will be used.
+	Profile string
+	// Per-task encoding overrides.
+	Options *Options
+	// Task priority, tasks with higher priority are launched first.
+	Priority int
+	// Per-task retry policy overrides.
+	Retry *RetryPolicy
+	// Per-task timeouts overrides.
+	Timeout *TimeoutPolicy
+	// How many times task was launched and finished. Set by ffmpeger.
+	Attempts int
+	// Task status, see Status* constants. Set by ffmpeger.
+	Status string
+	// Why task failed or was cancelled. Set by ffmpeger.
+	Error *TaskError
+
+	// Encoding profile resolved from Profile.
+	profile *config.Profile
 
-	// Filed in conversion.
-	totalFrames int
+	// When last progress event was published.
+	lastProgressAt time.Time
 
-	// Initial calculation state information.
-	previousOutput           string
-	gotInput                 bool
-	gotDuration              bool
-	gotTimeOrFPSParsingError bool
+	// Input media information obtained before conversion.
+	mediaInfo *MediaInfo
 
-	// After totalFrames will be filled we will use these variables
-	// to work with output.
-	gotFrame bool
+	// Last lines of ffmpeg's stderr.
+	stderr *stderrRing
 
-	// File info.
-	duration string
-	fps      string
+	// Indicates that ffmpeg was killed because of shutdown.
+	interrupted bool
+
+	// Cancellation request channel, created when task is launched.
+	cancel chan bool
+	// Indicates that ffmpeg was killed because of cancellation.
+	cancelled bool
+	// Why ffmpeg was killed by timeout. Empty if it wasn't.
+	timeoutReason string
+
+	// JetStream message task was received with. Nil if task was
+	// received via tasks topic subscription.
+	jetStreamMessage *nats.JetStreamMessage
 }
 
-// Convert launches conversion procedure. Should be launched in separate
-// goroutine.
-func (t *Task) Convert() {
+// Converts task and reports result. Returned error is also recorded
+// on task and included into result.
+func (c *Converter) convert(t *Task) error {
 	log.Printf("Starting conversion task: %+v\n", t)
-	currentlyRunningMutex.Lock()
-	currentlyRunning++
-	currentlyRunningMutex.Unlock()
-
-	defer func() {
-		currentlyRunningMutex.Lock()
-		currentlyRunning--
-		currentlyRunningMutex.Unlock()
-	}()
+	defer c.unmarkRunning(t)
+
+	t.stderr = newStderrRing(stderrTailLines)
+
+	startedAt := time.Now()
+	result := &Result{
+		TaskID:   t.ID,
+		ExitCode: -1,
+	}
+
+	taskErr := c.encode(t, result)
+
+	// Tasks interrupted by shutdown are returned to queue, shutdown
+	// procedure will decide what to do with them.
+	if t.interrupted {
+		log.Println("Task", t.ID, "was interrupted, returning it to queue")
+		c.setStatus(t, StatusQueued)
+		c.enqueue(t)
+		return nil
+	}
+
+	// Cancellation requested before ffmpeg was launched isn't received
+	// by anyone, but it was reported as successful already, so failed
+	// task shouldn't be retried.
+	if taskErr != nil && taskErr.Class != ErrorCancelled {
+		select {
+		case <-t.cancel:
+			log.Println("Task", t.ID, "was cancelled before ffmpeg was launched")
+			taskErr = newTaskError(ErrorCancelled, errors.New("task was cancelled"))
+		default:
+		}
+	}
+
+	t.Attempts++
+	policy := c.retryPolicy(t)
+
+	result.Status = StatusSucceeded
+	if taskErr != nil {
+		switch {
+		case taskErr.Class == ErrorCancelled:
+			result.Status = StatusCancelled
+		case shouldRetry(policy, t.Attempts, taskErr):
+			result.Status = StatusRetrying
+		default:
+			result.Status = StatusFailed
+		}
+	}
 
-	ffmpegCmd := exec.Command(ffmpegPath, "-i", t.InputFile, "-c:v", "libx264", "-b:v", "1000k", "-c:a", "aac", "-f", "mp4", t.OutputFile, "-y")
-	stderr, err := ffmpegCmd.StderrPipe()
+	result.Error = taskErr
+	result.Attempt = t.Attempts
+	result.WallTime = time.Since(startedAt).Seconds()
+	if t.mediaInfo != nil {
+		result.Duration = t.mediaInfo.Duration
+	}
+	result.StderrTail = t.stderr.Lines()
+	t.Error = taskErr
+	c.setStatus(t, result.Status)
+	c.publishResult(result)
+
+	switch result.Status {
+	case StatusRetrying:
+		// JetStream redelivers task itself, see acknowledge().
+		if t.jetStreamMessage == nil {
+			c.scheduleRetry(t, retryDelay(policy, t.Attempts))
+		}
+	case StatusFailed:
+		c.deadLetter(t)
+	}
+
+	// Typed nil shouldn't be returned as non-nil error.
+	if taskErr != nil {
+		return taskErr
+	}
+
+	return nil
+}
+
+// Checks input, launches ffmpeg and waits until it'll finish. Fills
+// result with ffmpeg's exit code and output size.
+func (c *Converter) encode(t *Task, result *Result) *TaskError {
+	// Tasks added with AddTask might not be validated yet.
+	if t.profile == nil {
+		err := t.validate(c.cfg.Profiles)
+		if err != nil {
+			return newTaskError(ErrorInvalid, errors.New("invalid task: "+err.Error()))
+		}
+	}
+
+	c.setStatus(t, StatusRunning)
+
+	mediaInfo, err := c.probe(t.InputFile, probeTimeout(c.timeoutPolicy(t)))
 	if err != nil {
-		log.Fatalln("Error while preparing to redirect ffmpeg's stderr:", err.Error())
+		t.interrupted = c.killRequested()
+		if err == errProbeTimeout {
+			return newTaskError(ErrorTimeout, errors.New("failed to probe input file: "+err.Error()))
+		}
+		return newTaskError(ErrorInput, errors.New("failed to probe input file: "+err.Error()))
+	}
+
+	err1 := t.checkMediaInfo(mediaInfo)
+	if err1 != nil {
+		return newTaskError(ErrorInvalid, err1)
+	}
+	t.mediaInfo = mediaInfo
+
+	ffmpegCmd := exec.Command(c.ffmpegPath, newArgumentsBuilder(t).Build()...)
+	setProcessGroup(ffmpegCmd)
+	stdout, err2 := ffmpegCmd.StdoutPipe()
+	if err2 != nil {
+		return newTaskError(ErrorStart, errors.New("failed to redirect ffmpeg's stdout: "+err2.Error()))
+	}
+	stderr, err3 := ffmpegCmd.StderrPipe()
+	if err3 != nil {
+		return newTaskError(ErrorStart, errors.New("failed to redirect ffmpeg's stderr: "+err3.Error()))
 	}
-	stderrScanner := bufio.NewScanner(stderr)
-	stderrScanner.Split(bufio.ScanWords)
 
-	// We will check state every 500ms.
+	err4 := ffmpegCmd.Start()
+	if err4 != nil {
+		return newTaskError(ErrorStart, errors.New("failed to start ffmpeg: "+err4.Error()))
+	}
+
+	// Watch for process exit, cancellation, timeouts and shutdown.
+	t.timeoutReason = ""
+	processExited := make(chan bool)
+	progressed := make(chan bool, 1)
+	watcherDone := make(chan bool)
 	go func() {
-		checkTick := time.NewTicker(time.Millisecond * 500)
-		err1 := ffmpegCmd.Start()
-		if err1 != nil {
-			log.Fatalln("Failed to start ffmpeg:", err1.Error())
+		defer close(watcherDone)
+		c.watchProcess(t, ffmpegCmd, processExited, progressed)
+	}()
+
+	// stderr is human-readable log which we keep for diagnostics.
+	stderrDone := make(chan bool)
+	go func() {
+		stderrScanner := bufio.NewScanner(stderr)
+		stderrScanner.Split(scanOutputLines)
+		for stderrScanner.Scan() {
+			t.stderr.Add(stderrScanner.Text())
 		}
+		stderrDone <- true
+	}()
 
-		for range checkTick.C {
-			// Should we shutdown immediately?
-			shouldShutdownMutex.Lock()
-			shouldWeStop := shouldShutdown
-			shouldShutdownMutex.Unlock()
-
-			if shouldWeStop {
-				log.Println("Killing converter goroutine...")
-				err := ffmpegCmd.Process.Kill()
-				if err != nil {
-					log.Println("ERROR: failed to kill ffmpeg process:", err.Error())
+	// stdout is machine-readable progress output.
+	parser := &progressParser{}
+	previousBlock := &progressBlock{}
+	stdoutScanner := bufio.NewScanner(stdout)
+	stdoutScanner.Split(scanOutputLines)
+	for stdoutScanner.Scan() {
+		block, completed := parser.Parse(stdoutScanner.Text())
+		if completed {
+			if block.advancedFrom(previousBlock) {
+				select {
+				case progressed <- true:
+				default:
+					// Watcher will be notified anyway.
 				}
-				ffmpegCmd.Process.Release()
-				break
 			}
+			previousBlock = block
+
+			c.workWithProgress(t, block)
 		}
+	}
 
-		log.Println("Child ffmpeg process killed")
-	}()
+	<-stderrDone
+	log.Println("Stopped reading ffmpeg output")
 
-	// Read output.
-	for {
-		// Should we shutdown immediately?
-		shouldShutdownMutex.Lock()
-		shouldWeStop := shouldShutdown
-		shouldShutdownMutex.Unlock()
-		if shouldWeStop {
-			break
-		}
+	// Error here means non-zero exit code or killed process, we will
+	// take everything from process state.
+	_ = ffmpegCmd.Wait()
+	close(processExited)
+	<-watcherDone
+
+	result.ExitCode = ffmpegCmd.ProcessState.ExitCode()
+
+	// Partial output is useless.
+	if t.cancelled {
+		removeOutput(t)
+		return newTaskError(ErrorCancelled, errors.New("task was cancelled"))
+	}
+
+	if t.timeoutReason != "" {
+		removeOutput(t)
+		return newTaskError(ErrorTimeout, errors.New(t.timeoutReason))
+	}
 
-		proceeding := stderrScanner.Scan()
-		if proceeding == false {
-			break
+	outputInfo, err6 := os.Stat(t.OutputFile)
+	if err6 == nil {
+		result.OutputSize = outputInfo.Size()
+	}
+
+	taskErr := classifyFailure(t, result.ExitCode, t.stderr.Lines())
+	if taskErr != nil {
+		return taskErr
+	}
+
+	if result.OutputSize == 0 {
+		return newTaskError(ErrorEncode, errors.New("ffmpeg produced no output"))
+	}
+
+	if c.cfg.Verify.Enabled {
+		err7 := c.verifyOutput(t)
+		if err7 != nil {
+			t.interrupted = c.killRequested()
+			// Broken output shouldn't be taken by anyone.
+			removeOutput(t)
+			return newTaskError(ErrorVerification, err7)
 		}
-		//log.Println(stderrScanner.Text())
-		t.workWithOutput(stderrScanner.Text())
 	}
 
-	log.Println("Stopped reading ffmpeg output")
+	return nil
 }
 
-// Printing progress for this task.
-func (t *Task) workWithOutput(output string) {
-	// Do nothing if we have empty output string or if we're not ready.
-	if output == "" || t.gotTimeOrFPSParsingError {
-		return
+// Waits until ffmpeg will exit and kills it on cancellation, timeout or
+// shutdown. Stall timer is restarted every time progress is made.
+func (c *Converter) watchProcess(t *Task, ffmpegCmd *exec.Cmd, processExited chan bool, progressed chan bool) {
+	timeout := c.timeoutPolicy(t)
+
+	// Nil channels disables timeouts.
+	var conversionTimedOut, stalled <-chan time.Time
+	if timeout.Conversion > 0 {
+		conversionTimer := time.NewTimer(timeout.Conversion)
+		defer conversionTimer.Stop()
+		conversionTimedOut = conversionTimer.C
 	}
 
-	// If we have totalFrames defined, which is the very final state
-	// for calculations below, we should work with output in different
-	// manner :)
-	if t.totalFrames != 0 {
-		// We should look for current frame count.
-		// If we have "frame=" there - then output for next function
-		// call will be current frame count.
-		if strings.Contains(output, "frame=") {
-			t.gotFrame = true
-			// If we have only "frame" here - then actual current frame
-			// will be in next output. Otherwise we should fix output
-			// to contain only actual current frame.
-			// We have ASCII here, not runes, len() is fine.
-			if len(output) == 6 {
-				// Current frame will be in next output.
-				return
-			} else {
-				output = strings.Split(output, "frame=")[1]
-			}
-		}
+	var stallTimer *time.Timer
+	if timeout.Stall > 0 {
+		stallTimer = time.NewTimer(timeout.Stall)
+		defer stallTimer.Stop()
+		stalled = stallTimer.C
+	}
 
-		// ... which we should properly use.
-		if t.gotFrame {
-			currentFrame, err := strconv.Atoi(output)
-			if err != nil {
-				log.Println("Failed to convert current frame value to int ("+output+"):", err.Error())
-				t.gotFrame = false
-				return
-			}
-			percentage := currentFrame / int(t.totalFrames/100)
-			// What if... we mistaken with totalFrames prediction?
-			if percentage > 100 {
-				percentage = 100
+	for {
+		select {
+		case <-processExited:
+			return
+		case <-progressed:
+			if stallTimer != nil {
+				if !stallTimer.Stop() {
+					<-stallTimer.C
+				}
+				stallTimer.Reset(timeout.Stall)
 			}
-			os.Stdout.Write([]byte("\rConverting " + t.InputFile + ": " + strconv.Itoa(percentage) + "% done (" + output + " frame of " + strconv.Itoa(t.totalFrames) + ")"))
+			continue
+		case <-conversionTimedOut:
+			log.Println("Killing ffmpeg of task", t.ID, "which runs longer than", timeout.Conversion)
+			t.timeoutReason = "conversion took longer than " + timeout.Conversion.String()
+		case <-stalled:
+			log.Println("Killing ffmpeg of task", t.ID, "which made no progress for", timeout.Stall)
+			t.timeoutReason = "no progress for " + timeout.Stall.String()
+		case <-t.cancel:
+			log.Println("Killing ffmpeg of cancelled task", t.ID+"...")
+			t.cancelled = true
+		case <-c.shutdownRequested:
+			log.Println("Killing converter goroutine...")
+			t.interrupted = true
+		}
 
-			// ... and reset it's state so next "frame=" will be the
-			// next stop.
-			t.gotFrame = false
+		err := killProcessGroup(ffmpegCmd)
+		if err != nil {
+			log.Println("ERROR: failed to kill ffmpeg process:", err.Error())
 		}
+		log.Println("Child ffmpeg process killed")
 
 		return
 	}
+}
 
-	// We got input keyword. Next function runs will look for duration.
-	if output == "Input" {
-		t.gotInput = true
-		return
+// Returns true if running tasks are being killed because of shutdown.
+func (c *Converter) killRequested() bool {
+	select {
+	case <-c.shutdownRequested:
+		return true
+	default:
+		return false
 	}
+}
 
-	if t.gotInput && output == "Duration:" {
-		t.gotDuration = true
-		return
+// Removes partial output file of task which was killed.
+func removeOutput(t *Task) {
+	err := os.Remove(t.OutputFile)
+	if err != nil && !os.IsNotExist(err) {
+		log.Println("ERROR: failed to remove output file of task", t.ID+":", err.Error())
 	}
+}
 
-	if t.gotDuration && t.duration == "" {
-		t.duration = output
-		log.Println("File duration:", t.duration)
-		return
+// Sets task status and saves task to store.
+func (c *Converter) setStatus(t *Task, status string) {
+	t.Status = status
+
+	err := c.store.Save(t)
+	if err != nil {
+		log.Println("ERROR: failed to save task", t.ID, "to store:", err.Error())
+	}
+}
+
+// Splits ffmpeg's output into lines. ffmpeg uses carriage return for
+// updating it's status line, so it's also treated as line delimiter.
+// Empty lines are skipped.
+func scanOutputLines(data []byte, atEOF bool) (int, []byte, error) {
+	start := 0
+	for start < len(data) && (data[start] == '\n' || data[start] == '\r') {
+		start++
+	}
+
+	for i := start; i < len(data); i++ {
+		if data[i] == '\n' || data[i] == '\r' {
+			return i + 1, data[start:i], nil
+		}
+	}
+
+	if atEOF && len(data) > start {
+		return len(data), data[start:], nil
+	}
+
+	// Request more data.
+	return start, nil, nil
+}
+
+// Checks that input media is suitable for the task.
+func (t *Task) checkMediaInfo(mediaInfo *MediaInfo) error {
+	video := mediaInfo.VideoStream()
+	audio := mediaInfo.AudioStream()
+
+	if video == nil && audio == nil {
+		return errors.New("input file has neither video nor audio streams")
+	}
+
+	if t.Options == nil {
+		return nil
+	}
+
+	if video == nil && (t.Options.Width != 0 || t.Options.Height != 0 || t.Options.FrameRate != 0) {
+		return errors.New("video options are set but input file has no video stream")
+	}
+
+	if audio == nil && t.Options.AudioChannels != 0 {
+		return errors.New("audio options are set but input file has no audio stream")
+	}
+
+	if mediaInfo.Duration != 0 && t.Options.start >= mediaInfo.DurationValue() {
+		return errors.New("start is beyond input file duration")
 	}
 
-	if t.duration != "" && output == "fps," {
-		t.fps = t.previousOutput
-		log.Println("Got FPS value:", t.fps)
-
-		// Calculate total frames approximately, because even if ffmpeg
-		// writes that there is 29.97 fps, it actually might be something
-		// like 29.971872638217638216.
-		// BTW, this is a duration, not a time, and to avoid all
-		// kind of type pr0n we will just fix gathered duration to
-		// be parsable by time.ParseDuration()
-		fileDuration := strings.Replace(t.duration, ":", "h", 1)
-		fileDuration = strings.Replace(fileDuration, ":", "m", 1)
-		fileDuration = strings.Replace(fileDuration, ".", "s", 1)
-		fileDuration = strings.Replace(fileDuration, ",", "", 1)
-		fileDuration += "ms"
-		totalTime, err := time.ParseDuration(fileDuration)
-		log.Println("Got file duration parsed:", totalTime)
-		seconds := totalTime.Seconds()
+	return nil
+}
+
+// Validates task and resolves it's encoding profile from passed
+// profiles.
+func (t *Task) validate(profiles map[string]config.Profile) error {
+	if t.InputFile == "" {
+		return errors.New("input file isn't specified")
+	}
+
+	// Probe tasks are also bounded by timeouts.
+	if t.Timeout != nil {
+		err := t.Timeout.validate()
 		if err != nil {
-			log.Println("ERROR: failed to parse video file total time value. No progress output will be produced!")
-			t.gotTimeOrFPSParsingError = true
+			return errors.New("invalid timeout: " + err.Error())
 		}
+	}
+
+	switch t.Type {
+	case "", TaskTypeConvert:
+	case TaskTypeProbe:
+		// Nothing else is needed for probing.
+		return nil
+	default:
+		return errors.New("unknown task type '" + t.Type + "'")
+	}
 
-		log.Println("Got file duration in seconds:", seconds)
-		fps, err1 := strconv.ParseFloat(t.fps, 64)
+	if t.OutputFile == "" {
+		return errors.New("output file isn't specified")
+	}
+
+	profile, err := getProfile(profiles, t.Profile)
+	if err != nil {
+		return err
+	}
+
+	t.profile = profile
+
+	if t.Options != nil {
+		err1 := t.Options.validate()
 		if err1 != nil {
-			log.Println("ERROR: failed to parse frames per second value: '" + t.fps + "', no progress output will be produced!")
-			t.gotTimeOrFPSParsingError = true
+			return errors.New("invalid options: " + err1.Error())
 		}
-		// We don't mind to loose 1 or 2 fps from total fps counter,
-		// yea? :)
-		t.totalFrames = int(float64(seconds) * fps)
-		log.Println("Total frames calculated:", t.totalFrames)
 	}
 
-	// Save previous output for good unless we have everything we need
-	// to print progress.
-	if t.totalFrames == 0 {
-		t.previousOutput = output
+	if t.Retry != nil {
+		err2 := t.Retry.validate()
+		if err2 != nil {
+			return errors.New("invalid retry policy: " + err2.Error())
+		}
 	}
+
+	return nil
 }
@@ -0,0 +1,194 @@
+package converter
+
+import (
+	// stdlib
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	// local
+	"github.com/pztrn/ffmpeger/config"
+
+	// other
+	"github.com/stretchr/testify/require"
+)
+
+// Writes shell script which will be launched instead of ffmpeg or
+// ffprobe and returns path to it.
+func writeFakeBinary(t *testing.T, dir string, name string, script string) string {
+	path := filepath.Join(dir, name)
+	err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0755)
+	if err != nil {
+		t.Fatal("Failed to write fake binary:", err.Error())
+	}
+
+	return path
+}
+
+func TestConvertErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ffmpeger-test-convert")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	fixture, err1 := filepath.Abs(filepath.Join("testdata", "ffprobe_video.json"))
+	require.Nil(t, err1)
+	outputFile := filepath.Join(dir, "out.mp4")
+
+	ffprobe := writeFakeBinary(t, dir, "ffprobe", "cat "+fixture)
+	brokenFFprobe := writeFakeBinary(t, dir, "ffprobe-broken", "echo 'Permission denied' >&2; exit 1")
+	// sleep is a child of shell which holds output pipes, so test will
+	// wait for it unless whole process group is killed.
+	stalledFFmpeg := writeFakeBinary(t, dir, "ffmpeg-stalled", "sleep 30")
+	// Reports progress forever.
+	slowFFmpeg := writeFakeBinary(t, dir, "ffmpeg-slow", "i=0; while true; do i=$((i+1)); echo frame=$i; echo progress=continue; sleep 0.05; done")
+	// Probes input file, but output file is reported as truncated.
+	truncatingFFprobe := writeFakeBinary(t, dir, "ffprobe-truncating", "for last; do :; done; if [ \"$last\" = "+outputFile+" ]; then echo 'moov atom not found' >&2; exit 1; fi; cat "+fixture)
+
+	tests := []struct {
+		name       string
+		task       *Task
+		ffprobe    string
+		ffmpeg     string
+		verify     bool
+		status     string
+		errorClass string
+	}{
+		{
+			name:       "invalid task",
+			task:       &Task{InputFile: "/tmp/in.mkv"},
+			ffprobe:    ffprobe,
+			ffmpeg:     "/nonexistent/ffmpeg",
+			status:     StatusFailed,
+			errorClass: ErrorInvalid,
+		},
+		{
+			name:       "unreadable input",
+			task:       &Task{InputFile: "/tmp/in.mkv", OutputFile: outputFile},
+			ffprobe:    brokenFFprobe,
+			ffmpeg:     "/nonexistent/ffmpeg",
+			status:     StatusFailed,
+			errorClass: ErrorInput,
+		},
+		{
+			name:       "unsuitable input",
+			task:       &Task{InputFile: "/tmp/in.mkv", OutputFile: outputFile, Options: &Options{Start: "120"}},
+			ffprobe:    ffprobe,
+			ffmpeg:     "/nonexistent/ffmpeg",
+			status:     StatusFailed,
+			errorClass: ErrorInvalid,
+		},
+		{
+			name:       "ffmpeg is missing",
+			task:       &Task{InputFile: "/tmp/in.mkv", OutputFile: outputFile},
+			ffprobe:    ffprobe,
+			ffmpeg:     "/nonexistent/ffmpeg",
+			status:     StatusFailed,
+			errorClass: ErrorStart,
+		},
+		{
+			name:       "ffmpeg fails",
+			task:       &Task{InputFile: "/tmp/in.mkv", OutputFile: outputFile},
+			ffprobe:    ffprobe,
+			ffmpeg:     writeFakeBinary(t, dir, "ffmpeg-failing", "exit 1"),
+			status:     StatusFailed,
+			errorClass: ErrorEncode,
+		},
+		{
+			name:       "ffmpeg refuses to start conversion",
+			task:       &Task{InputFile: "/tmp/in.mkv", OutputFile: outputFile},
+			ffprobe:    ffprobe,
+			ffmpeg:     writeFakeBinary(t, dir, "ffmpeg-unknown-encoder", "echo \"Unknown encoder 'libx265'\" >&2; exit 1"),
+			status:     StatusFailed,
+			errorClass: ErrorStart,
+		},
+		{
+			name:       "ffprobe hangs",
+			task:       &Task{InputFile: "/tmp/in.mkv", OutputFile: outputFile, Timeout: &TimeoutPolicy{Stall: 0.2}},
+			ffprobe:    stalledFFmpeg,
+			ffmpeg:     "/nonexistent/ffmpeg",
+			status:     StatusFailed,
+			errorClass: ErrorTimeout,
+		},
+		{
+			name:       "ffmpeg stalls",
+			task:       &Task{InputFile: "/tmp/in.mkv", OutputFile: outputFile, Timeout: &TimeoutPolicy{Stall: 0.2}},
+			ffprobe:    ffprobe,
+			ffmpeg:     stalledFFmpeg,
+			status:     StatusFailed,
+			errorClass: ErrorTimeout,
+		},
+		{
+			name:       "ffmpeg runs too long",
+			task:       &Task{InputFile: "/tmp/in.mkv", OutputFile: outputFile, Timeout: &TimeoutPolicy{Conversion: 0.3, Stall: 30}},
+			ffprobe:    ffprobe,
+			ffmpeg:     slowFFmpeg,
+			status:     StatusFailed,
+			errorClass: ErrorTimeout,
+		},
+		{
+			name:       "no output",
+			task:       &Task{InputFile: "/tmp/in.mkv", OutputFile: outputFile},
+			ffprobe:    ffprobe,
+			ffmpeg:     writeFakeBinary(t, dir, "ffmpeg-silent", "exit 0"),
+			status:     StatusFailed,
+			errorClass: ErrorEncode,
+		},
+		{
+			name:       "unreadable output",
+			task:       &Task{InputFile: "/tmp/in.mkv", OutputFile: outputFile},
+			ffprobe:    truncatingFFprobe,
+			ffmpeg:     writeFakeBinary(t, dir, "ffmpeg-truncating", "echo converted > "+outputFile),
+			verify:     true,
+			status:     StatusFailed,
+			errorClass: ErrorVerification,
+		},
+		{
+			name:    "verified",
+			task:    &Task{InputFile: "/tmp/in.mkv", OutputFile: outputFile},
+			ffprobe: ffprobe,
+			ffmpeg:  writeFakeBinary(t, dir, "ffmpeg-verified", "echo converted > "+outputFile),
+			verify:  true,
+			status:  StatusSucceeded,
+		},
+		{
+			name:    "succeeded",
+			task:    &Task{InputFile: "/tmp/in.mkv", OutputFile: outputFile},
+			ffprobe: ffprobe,
+			ffmpeg:  writeFakeBinary(t, dir, "ffmpeg", "echo converted > "+outputFile),
+			status:  StatusSucceeded,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			os.Remove(outputFile)
+
+			cfg := &config.Config{}
+			cfg.Verify.Enabled = test.verify
+			// Failed tasks should fail for good.
+			cfg.Retry.MaxAttempts = 1
+			c, err := New(cfg, newTestClient(t, cfg), WithStore(newMemoryStore()), WithFFmpegPath(test.ffmpeg), WithFFprobePath(test.ffprobe))
+			require.Nil(t, err)
+
+			c.AddTask(test.task)
+			task := c.nextTask()
+			err1 := c.convert(task)
+			require.Equal(t, test.status, task.Status)
+
+			if test.errorClass == "" {
+				require.Nil(t, err1)
+				require.Nil(t, task.Error)
+				return
+			}
+
+			taskErr := &TaskError{}
+			require.True(t, errors.As(err1, &taskErr))
+			require.Equal(t, test.errorClass, taskErr.Class)
+			require.Equal(t, taskErr, task.Error)
+		})
+	}
+}
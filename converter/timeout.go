@@ -0,0 +1,47 @@
+package converter
+
+import (
+	// stdlib
+	"errors"
+	"time"
+
+	// local
+	"github.com/pztrn/ffmpeger/config"
+)
+
+// TimeoutPolicy overrides configured timeouts for single task. Values
+// are in seconds, zero values means "not set".
+type TimeoutPolicy struct {
+	// Maximum conversion wall time.
+	Conversion float64
+	// How long ffmpeg can go without progress.
+	Stall float64
+}
+
+// Validates timeout overrides.
+func (p *TimeoutPolicy) validate() error {
+	if p.Conversion < 0 || p.Stall < 0 {
+		return errors.New("conversion and stall timeouts can't be negative")
+	}
+
+	return nil
+}
+
+// Returns timeouts for task, which are configured timeouts with task's
+// overrides applied.
+func (c *Converter) timeoutPolicy(t *Task) config.Timeout {
+	timeout := c.cfg.Timeout
+	if t.Timeout == nil {
+		return timeout
+	}
+
+	if t.Timeout.Conversion != 0 {
+		timeout.Conversion = time.Duration(t.Timeout.Conversion * float64(time.Second))
+	}
+
+	if t.Timeout.Stall != 0 {
+		timeout.Stall = time.Duration(t.Timeout.Stall * float64(time.Second))
+	}
+
+	return timeout
+}
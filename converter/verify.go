@@ -0,0 +1,51 @@
+package converter
+
+import (
+	// stdlib
+	"errors"
+	"time"
+)
+
+// Probes output file of converted task and checks that it's readable
+// and matches input.
+func (c *Converter) verifyOutput(t *Task) error {
+	output, err := c.probe(t.OutputFile, probeTimeout(c.timeoutPolicy(t)))
+	if err != nil {
+		return errors.New("output file isn't readable: " + err.Error())
+	}
+
+	return t.checkOutput(output, c.cfg.Verify.DurationTolerance)
+}
+
+// Checks that output media has streams which input has and it's
+// duration matches expected one within passed tolerance.
+func (t *Task) checkOutput(output *MediaInfo, tolerance time.Duration) error {
+	if output.Container == "" || len(output.Streams) == 0 {
+		return errors.New("output file has no streams")
+	}
+
+	if t.mediaInfo.VideoStream() != nil && output.VideoStream() == nil {
+		return errors.New("output file has no video stream")
+	}
+
+	if t.mediaInfo.AudioStream() != nil && output.AudioStream() == nil {
+		return errors.New("output file has no audio stream")
+	}
+
+	// Nothing to compare with.
+	expected := t.Options.outputDuration(t.mediaInfo.DurationValue())
+	if expected == 0 {
+		return nil
+	}
+
+	difference := output.DurationValue() - expected
+	if difference < 0 {
+		difference = -difference
+	}
+
+	if difference > tolerance {
+		return errors.New("output duration " + formatSeconds(output.DurationValue()) + "s differs from expected " + formatSeconds(expected) + "s")
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package converter
+
+import (
+	// stdlib
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	// other
+	"github.com/stretchr/testify/require"
+)
+
+// Reads and parses recorded ffprobe's output.
+func parseProbeFixture(t *testing.T, name string) *MediaInfo {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal("Failed to read fixture:", err.Error())
+	}
+
+	mediaInfo, err1 := parseProbeOutput(data)
+	require.Nil(t, err1)
+
+	return mediaInfo
+}
+
+func TestCheckOutput(t *testing.T) {
+	video := parseProbeFixture(t, "ffprobe_video.json")
+	audioOnly := parseProbeFixture(t, "ffprobe_audio.json")
+
+	truncated := parseProbeFixture(t, "ffprobe_video.json")
+	truncated.Duration = 30
+
+	trimmed := parseProbeFixture(t, "ffprobe_video.json")
+	trimmed.Duration = 10.5
+
+	tests := []struct {
+		name    string
+		input   *MediaInfo
+		output  *MediaInfo
+		options *Options
+		valid   bool
+	}{
+		{name: "same media", input: video, output: video, valid: true},
+		{name: "truncated", input: video, output: truncated},
+		{name: "trimmed", input: video, output: trimmed, options: &Options{Start: "10", End: "20"}, valid: true},
+		{name: "video lost", input: video, output: audioOnly},
+		{name: "no streams", input: video, output: &MediaInfo{Container: "mp4", Duration: 60.06}},
+		{name: "audio to video", input: audioOnly, output: &MediaInfo{Container: "mp4", Duration: 180, Streams: []StreamInfo{{Type: StreamTypeVideo}}}},
+		{name: "unknown input duration", input: &MediaInfo{Streams: video.Streams}, output: truncated, valid: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			task := &Task{Options: test.options, mediaInfo: test.input}
+			if test.options != nil {
+				require.Nil(t, test.options.validate())
+			}
+
+			err := task.checkOutput(test.output, time.Second)
+			if test.valid {
+				require.Nil(t, err)
+			} else {
+				require.NotNil(t, err)
+			}
+		})
+	}
+}
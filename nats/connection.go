@@ -0,0 +1,153 @@
+package nats
+
+import (
+	// stdlib
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	// local
+	"github.com/pztrn/ffmpeger/config"
+
+	// other
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// ConnectionConnected means that connection to NATS is established.
+	ConnectionConnected = "connected"
+	// ConnectionDisconnected means that connection to NATS was lost and
+	// client is trying to reconnect.
+	ConnectionDisconnected = "disconnected"
+	// ConnectionClosed means that connection is closed and will not be
+	// restored.
+	ConnectionClosed = "closed"
+)
+
+// Health represents NATS connection state.
+type Health struct {
+	// Connection status, see Connection* constants. Empty if connection
+	// was never established.
+	Status string
+	// Server we're connected to.
+	Server string
+	// How many times connection was lost and restored.
+	Disconnects int
+	Reconnects  int
+	// When status was changed last time.
+	Since time.Time
+	// Last connection error, if any.
+	LastError string
+}
+
+// Health returns current NATS connection state.
+func (c *Client) Health() Health {
+	c.healthMutex.Lock()
+	defer c.healthMutex.Unlock()
+
+	return c.health
+}
+
+// Replies to health request with connection state.
+func (c *Client) healthMessageHandler(msg *nats.Msg) {
+	if msg.Reply == "" {
+		return
+	}
+
+	data, err := json.Marshal(c.Health())
+	if err != nil {
+		log.Println("ERROR: failed to encode health status:", err.Error())
+		return
+	}
+
+	err1 := c.Publish(msg.Reply, data)
+	if err1 != nil {
+		log.Println("ERROR: failed to send health status:", err1.Error())
+	}
+}
+
+// Updates connection state.
+func (c *Client) setConnectionStatus(status string, nc *nats.Conn) {
+	c.healthMutex.Lock()
+	defer c.healthMutex.Unlock()
+
+	health := &c.health
+	health.Status = status
+	health.Since = time.Now()
+
+	if nc != nil {
+		health.Server = nc.ConnectedUrl()
+		if nc.LastError() != nil {
+			health.LastError = nc.LastError().Error()
+		}
+	}
+
+	switch status {
+	case ConnectionDisconnected:
+		health.Disconnects++
+	case ConnectionConnected:
+		if health.Disconnects > 0 {
+			health.Reconnects++
+		}
+	}
+}
+
+// Composes connection options from configuration, adding callbacks
+// which keep client's health up to date.
+func (c *Client) connectionOptions() ([]nats.Option, error) {
+	options, err := ConnectionOptions(c.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(options,
+		nats.DisconnectHandler(func(nc *nats.Conn) {
+			log.Println("ERROR: disconnected from NATS, reconnecting...")
+			c.setConnectionStatus(ConnectionDisconnected, nc)
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			log.Println("Reconnected to NATS at", nc.ConnectedUrl())
+			c.setConnectionStatus(ConnectionConnected, nc)
+		}),
+		nats.ClosedHandler(func(nc *nats.Conn) {
+			log.Println("NATS connection closed")
+			c.setConnectionStatus(ConnectionClosed, nc)
+		}),
+	), nil
+}
+
+// ConnectionOptions composes NATS connection options (authentication,
+// TLS and reconnection) from configuration.
+func ConnectionOptions(cfg *config.Nats) ([]nats.Option, error) {
+	options := []nats.Option{
+		nats.Name("ffmpeger"),
+		nats.ReconnectWait(cfg.ReconnectWait),
+		nats.MaxReconnects(cfg.MaxReconnects),
+	}
+
+	switch {
+	case cfg.CredentialsFile != "":
+		options = append(options, nats.UserCredentials(cfg.CredentialsFile))
+	case cfg.NKeySeedFile != "":
+		nkeyOption, err := nats.NkeyOptionFromSeed(cfg.NKeySeedFile)
+		if err != nil {
+			return nil, errors.New("Failed to load NKey seed: " + err.Error())
+		}
+		options = append(options, nkeyOption)
+	case cfg.User != "" || cfg.Password != "":
+		options = append(options, nats.UserInfo(cfg.User, cfg.Password))
+	case cfg.Token != "":
+		options = append(options, nats.Token(cfg.Token))
+	}
+
+	if cfg.TLS.CAFile != "" {
+		options = append(options, nats.RootCAs(cfg.TLS.CAFile))
+	}
+
+	if cfg.TLS.CertFile != "" {
+		options = append(options, nats.ClientCert(cfg.TLS.CertFile, cfg.TLS.KeyFile))
+	}
+
+	return options, nil
+}
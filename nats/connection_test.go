@@ -0,0 +1,135 @@
+package nats
+
+import (
+	// stdlib
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	// local
+	"github.com/pztrn/ffmpeger/config"
+
+	// other
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"github.com/stretchr/testify/require"
+)
+
+// Applies connection options composed from passed configuration.
+func applyConnectionOptions(cfg *config.Nats) (*nats.Options, error) {
+	c, err := NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	options, err1 := c.connectionOptions()
+	if err1 != nil {
+		return nil, err1
+	}
+
+	opts := nats.GetDefaultOptions()
+	for _, option := range options {
+		err2 := option(&opts)
+		if err2 != nil {
+			return nil, err2
+		}
+	}
+
+	return &opts, nil
+}
+
+func TestConnectionOptions(t *testing.T) {
+	opts, err := applyConnectionOptions(&config.Nats{
+		User:          "ffmpeger",
+		Password:      "secret",
+		ReconnectWait: time.Second * 5,
+		MaxReconnects: -1,
+	})
+	require.Nil(t, err)
+	require.Equal(t, "ffmpeger", opts.Name)
+	require.Equal(t, "ffmpeger", opts.User)
+	require.Equal(t, "secret", opts.Password)
+	require.Equal(t, time.Second*5, opts.ReconnectWait)
+	require.Equal(t, -1, opts.MaxReconnect)
+	require.False(t, opts.Secure)
+	require.NotNil(t, opts.DisconnectedCB)
+	require.NotNil(t, opts.ReconnectedCB)
+	require.NotNil(t, opts.ClosedCB)
+
+	opts1, err1 := applyConnectionOptions(&config.Nats{Token: "token"})
+	require.Nil(t, err1)
+	require.Equal(t, "token", opts1.Token)
+
+	opts2, err2 := applyConnectionOptions(&config.Nats{CredentialsFile: "/etc/ffmpeger/user.creds"})
+	require.Nil(t, err2)
+	require.NotNil(t, opts2.UserJWT)
+	require.NotNil(t, opts2.SignatureCB)
+}
+
+func TestConnectionOptionsNKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ffmpeger-test-nkey")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	user, err1 := nkeys.CreateUser()
+	require.Nil(t, err1)
+	seed, err2 := user.Seed()
+	require.Nil(t, err2)
+	publicKey, err3 := user.PublicKey()
+	require.Nil(t, err3)
+
+	seedFile := filepath.Join(dir, "user.nk")
+	err4 := ioutil.WriteFile(seedFile, seed, 0600)
+	if err4 != nil {
+		t.Fatal("Failed to write seed file:", err4.Error())
+	}
+
+	opts, err5 := applyConnectionOptions(&config.Nats{NKeySeedFile: seedFile})
+	require.Nil(t, err5)
+	require.Equal(t, publicKey, opts.Nkey)
+
+	_, err6 := applyConnectionOptions(&config.Nats{NKeySeedFile: filepath.Join(dir, "nonexistent.nk")})
+	require.NotNil(t, err6)
+}
+
+func TestConnectionOptionsTLS(t *testing.T) {
+	opts, err := applyConnectionOptions(&config.Nats{
+		TLS: config.NatsTLS{
+			CertFile: "testdata/client.pem",
+			KeyFile:  "testdata/client-key.pem",
+			CAFile:   "testdata/ca.pem",
+		},
+	})
+	require.Nil(t, err)
+	require.True(t, opts.Secure)
+	require.Len(t, opts.TLSConfig.Certificates, 1)
+	require.NotNil(t, opts.TLSConfig.RootCAs)
+
+	_, err1 := applyConnectionOptions(&config.Nats{
+		TLS: config.NatsTLS{CAFile: "testdata/nonexistent.pem"},
+	})
+	require.NotNil(t, err1)
+}
+
+func TestConnectionHealth(t *testing.T) {
+	c := newTestClient(t, &config.Nats{})
+	require.Empty(t, c.Health().Status)
+
+	c.setConnectionStatus(ConnectionConnected, nil)
+	require.Equal(t, ConnectionConnected, c.Health().Status)
+	require.Equal(t, 0, c.Health().Reconnects)
+
+	c.setConnectionStatus(ConnectionDisconnected, nil)
+	c.setConnectionStatus(ConnectionConnected, nil)
+	c.setConnectionStatus(ConnectionClosed, nil)
+
+	h := c.Health()
+	require.Equal(t, ConnectionClosed, h.Status)
+	require.Equal(t, 1, h.Disconnects)
+	require.Equal(t, 1, h.Reconnects)
+	require.False(t, h.Since.IsZero())
+}
@@ -0,0 +1,58 @@
+package nats
+
+import (
+	// stdlib
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// SchemaVersion1 is a version of messages sent without envelope.
+const SchemaVersion1 = 1
+
+// Envelope wraps message with it's schema version, so messages in
+// different formats can be sent to same subject during migrations.
+// Messages without envelope have schema version 1.
+type Envelope struct {
+	Version int
+	Payload json.RawMessage
+}
+
+// Unwrap returns message's schema version, payload and flag which
+// indicates that message had envelope (so reply should have it too).
+// If message has no envelope it's returned as is with version 1.
+func Unwrap(data []byte) (int, []byte, bool, error) {
+	var fields map[string]json.RawMessage
+	err1 := json.Unmarshal(data, &fields)
+	if err1 != nil {
+		// Not a JSON object, so not an envelope either. It's up to
+		// receiver to decide what to do with it.
+		return SchemaVersion1, data, false, nil
+	}
+
+	_, hasVersion := fields["Version"]
+	_, hasPayload := fields["Payload"]
+	if len(fields) != 2 || !hasVersion || !hasPayload {
+		return SchemaVersion1, data, false, nil
+	}
+
+	envelope := &Envelope{}
+	err2 := json.Unmarshal(data, envelope)
+	if err2 != nil {
+		return 0, nil, true, errors.New("malformed envelope: " + err2.Error())
+	}
+
+	if envelope.Version < SchemaVersion1 {
+		return 0, nil, true, errors.New("invalid schema version " + strconv.Itoa(envelope.Version))
+	}
+
+	return envelope.Version, envelope.Payload, true, nil
+}
+
+// Wrap wraps payload into envelope with passed schema version.
+func Wrap(version int, payload []byte) ([]byte, error) {
+	return json.Marshal(&Envelope{
+		Version: version,
+		Payload: json.RawMessage(payload),
+	})
+}
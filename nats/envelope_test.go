@@ -0,0 +1,71 @@
+package nats
+
+import (
+	// stdlib
+	"testing"
+
+	// other
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnwrap(t *testing.T) {
+	tests := []struct {
+		name      string
+		message   string
+		version   int
+		payload   string
+		enveloped bool
+		valid     bool
+	}{
+		{"raw task", `{"InputFile": "/tmp/in.mkv"}`, 1, `{"InputFile": "/tmp/in.mkv"}`, false, true},
+		{"not a JSON", `Hello, world!`, 1, `Hello, world!`, false, true},
+		{"envelope", `{"Version": 2, "Payload": {"Input": "/tmp/in.mkv"}}`, 2, `{"Input": "/tmp/in.mkv"}`, true, true},
+		{"payload with extra fields", `{"Version": 2, "Payload": {}, "InputFile": "/tmp/in.mkv"}`, 1, `{"Version": 2, "Payload": {}, "InputFile": "/tmp/in.mkv"}`, false, true},
+		{"bad version", `{"Version": "2", "Payload": {}}`, 0, ``, true, false},
+		{"zero version", `{"Version": 0, "Payload": {}}`, 0, ``, true, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			version, payload, enveloped, err := Unwrap([]byte(test.message))
+			require.Equal(t, test.enveloped, enveloped)
+			if !test.valid {
+				require.NotNil(t, err)
+				return
+			}
+
+			require.Nil(t, err)
+			require.Equal(t, test.version, version)
+			require.Equal(t, test.payload, string(payload))
+		})
+	}
+}
+
+func TestWrap(t *testing.T) {
+	data, err := Wrap(2, []byte(`{"Status":"accepted"}`))
+	require.Nil(t, err)
+	require.Equal(t, `{"Version":2,"Payload":{"Status":"accepted"}}`, string(data))
+
+	version, payload, enveloped, err1 := Unwrap(data)
+	require.Nil(t, err1)
+	require.True(t, enveloped)
+	require.Equal(t, 2, version)
+	require.Equal(t, `{"Status":"accepted"}`, string(payload))
+}
+
+func TestSubjects(t *testing.T) {
+	subjects := NewSubjects("")
+	require.Equal(t, "ffmpeger.v1", subjects.Submit)
+	require.Equal(t, "ffmpeger.v1.control", subjects.Control)
+	require.Equal(t, "ffmpeger.v1.probe", subjects.Probe)
+	require.Equal(t, "ffmpeger.v1.results", subjects.Results)
+	require.Equal(t, "ffmpeger.v1.health", subjects.Health)
+	require.Equal(t, "ffmpeger.v1.deadletter", subjects.DeadLetter)
+	require.Equal(t, "ffmpeger.v1.progress.task", subjects.Progress("task"))
+
+	staging := NewSubjects("staging.ffmpeger")
+	require.Equal(t, "staging.ffmpeger", staging.Submit)
+	require.Equal(t, "staging.ffmpeger.results", staging.Results)
+	require.Equal(t, staging.Control, staging.byName(SubjectControl))
+	require.Equal(t, "staging.ffmpeger.thumbnails", staging.byName("thumbnails"))
+}
@@ -2,9 +2,11 @@ package nats
 
 import (
 	// stdlib
+	"context"
 	"errors"
 	"log"
 	"sync"
+	"time"
 
 	// local
 	"github.com/pztrn/ffmpeger/config"
@@ -13,79 +15,282 @@ import (
 	"github.com/nats-io/nats.go"
 )
 
-const (
-	Topic = "ffmpeger.v1"
-)
+// Client represents connection to NATS and handlers which receive
+// messages from it.
+type Client struct {
+	cfg      *config.Nats
+	subjects *Subjects
 
-var (
-	natsConn         *nats.Conn
-	natsSubscription *nats.Subscription
+	conn *nats.Conn
+	// Protects connection, which is replaced on connect and cleared on
+	// shutdown while handlers might publish replies.
+	connMutex sync.Mutex
 
-	// Handlers.
-	handlers      []*Handler
+	// Handlers by name.
+	handlers map[string]*Handler
+	// Indicates that handlers should be subscribed to their subjects.
+	listening bool
+	// Protects handlers, their subscriptions and listening flag.
 	handlersMutex sync.Mutex
-)
 
-// AddHandler adds handler for received NATS messages.
-func AddHandler(hndl *Handler) {
-	handlersMutex.Lock()
-	handlers = append(handlers, hndl)
-	handlersMutex.Unlock()
+	health      Health
+	healthMutex sync.Mutex
 }
 
-// Initialize initializes package.
-func Initialize() {
-	log.Println("Initializing NATS handler...")
+// NewClient creates NATS client for passed configuration. Configuration
+// is validated and defaults are filled. Connection is established by
+// StartListening.
+func NewClient(cfg *config.Nats) (*Client, error) {
+	log.Println("Initializing NATS client...")
+
+	err := cfg.Validate()
+	if err != nil {
+		return nil, errors.New("Invalid NATS configuration: " + err.Error())
+	}
 
-	handlers = make([]*Handler, 0, 8)
+	c := &Client{
+		cfg:      cfg,
+		subjects: NewSubjects(cfg.SubjectPrefix),
+		handlers: make(map[string]*Handler),
+	}
+
+	return c, nil
 }
 
-// Handler for NATS messages.
-func messageHandler(msg *nats.Msg) {
-	log.Println("Received message:", string(msg.Data))
+// AddHandler adds handler for received NATS messages. Handler names
+// should be unique and only one handler can be added for every subject.
+// If we're listening for messages already handler is subscribed
+// immediately.
+func (c *Client) AddHandler(hndl *Handler) error {
+	if hndl.New == nil || hndl.Func == nil {
+		return errors.New("handler " + hndl.Name + " should have both New and Func")
+	}
 
-	handlersMutex.Lock()
-	for _, hndl := range handlers {
-		hndl.Func(msg.Data)
+	err := validateSubjectName(hndl.subject())
+	if err != nil {
+		return errors.New("handler " + hndl.Name + " has invalid subject: " + err.Error())
 	}
-	handlersMutex.Unlock()
+
+	c.handlersMutex.Lock()
+	defer c.handlersMutex.Unlock()
+
+	for _, existing := range c.handlers {
+		if existing.Name == hndl.Name {
+			return errors.New("handler " + hndl.Name + " is already added")
+		}
+
+		if existing.subject() == hndl.subject() {
+			return errors.New("handler " + existing.Name + " is already added for " + hndl.subject() + " subject")
+		}
+	}
+
+	if c.listening {
+		err1 := hndl.start(c)
+		if err1 != nil {
+			return err1
+		}
+	}
+
+	c.handlers[hndl.Name] = hndl
+	log.Println("NATS handler", hndl.Name, "added")
+
+	return nil
 }
 
-// Shutdown unsubscribes from topic and disconnects from NATS.
-func Shutdown() error {
-	log.Println("Unsuscribing from NATS topic...")
-	err := natsSubscription.Unsubscribe()
+// RemoveHandler unsubscribes and removes handler with passed name.
+func (c *Client) RemoveHandler(name string) error {
+	c.handlersMutex.Lock()
+	defer c.handlersMutex.Unlock()
+
+	hndl, found := c.handlers[name]
+	if !found {
+		return errors.New("handler " + name + " isn't added")
+	}
+
+	err := hndl.stop()
 	if err != nil {
-		return errors.New("ERROR unsubscribing " + Topic + " topic: " + err.Error())
+		return err
 	}
 
-	if natsConn != nil {
-		log.Println("Closing connection to NATS...")
-		natsConn.Close()
+	delete(c.handlers, name)
+	log.Println("NATS handler", name, "removed")
+
+	return nil
+}
+
+// Publish publishes data to passed subject.
+func (c *Client) Publish(subject string, data []byte) error {
+	nc := c.connection()
+	if nc == nil {
+		return errors.New("Not connected to NATS")
+	}
+
+	err := nc.Publish(subject, data)
+	if err != nil {
+		return errors.New("Failed to publish message to " + subject + ": " + err.Error())
 	}
 
 	return nil
 }
 
-// StartListening connects to NATS and starts to listen for messages.
-func StartListening() error {
-	nc, err := nats.Connect(config.Cfg.NATS.ConnectionString)
+// Request sends request to passed subject and returns reply data.
+func (c *Client) Request(subject string, data []byte, timeout time.Duration) ([]byte, error) {
+	nc := c.connection()
+	if nc == nil {
+		return nil, errors.New("Not connected to NATS")
+	}
+
+	msg, err := nc.Request(subject, data, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return msg.Data, nil
+}
+
+// RequestWithContext sends request to passed subject and waits for
+// reply until passed context is done.
+func (c *Client) RequestWithContext(ctx context.Context, subject string, data []byte) ([]byte, error) {
+	nc := c.connection()
+	if nc == nil {
+		return nil, errors.New("Not connected to NATS")
+	}
+
+	msg, err := nc.RequestWithContext(ctx, subject, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return msg.Data, nil
+}
+
+// Shutdown unsubscribes from topics and disconnects from NATS.
+func (c *Client) Shutdown() error {
+	err := c.StopListening()
+	if err != nil {
+		return err
+	}
+
+	log.Println("Closing connection to NATS...")
+	c.connMutex.Lock()
+	nc := c.conn
+	c.conn = nil
+	c.connMutex.Unlock()
+
+	// Publishing to closed connection fails, so it's safe to close it
+	// while someone still holds it.
+	if nc != nil {
+		nc.Close()
+	}
+
+	return nil
+}
+
+// StopListening unsubscribes handlers, so no more messages will be
+// received. Connection stays open, so messages still can be published.
+func (c *Client) StopListening() error {
+	if c.connection() == nil {
+		return errors.New("Not connected to NATS")
+	}
+
+	log.Println("Unsuscribing from NATS topics...")
+
+	c.handlersMutex.Lock()
+	defer c.handlersMutex.Unlock()
+
+	c.listening = false
+	for _, hndl := range c.handlers {
+		err := hndl.stop()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// StartListening connects to NATS and subscribes handlers to their
+// subjects.
+func (c *Client) StartListening() error {
+	options, err := c.connectionOptions()
+	if err != nil {
+		return errors.New("Failed to prepare NATS connection options: " + err.Error())
+	}
+
+	nc, err := nats.Connect(c.cfg.ConnectionString, options...)
 	if err != nil {
 		return errors.New("Failed to connect to NATS:" + err.Error())
 	}
 
-	natsConn = nc
+	c.connMutex.Lock()
+	c.conn = nc
+	c.connMutex.Unlock()
+	c.setConnectionStatus(ConnectionConnected, nc)
 	log.Println("NATS connection established")
 
-	// Beware - if ffmpeger will be launched more than once and subscribed
-	// to same topic (which is hardcoded here) then ALL instances of
-	// ffmpeger will receive this message!
-	sub, err1 := nc.Subscribe(Topic, messageHandler)
-	if err1 != nil {
-		return errors.New("Failed to subscribe to " + Topic + " topic: " + err1.Error())
+	// Health requests are served until connection will be closed, so
+	// it's possible to watch draining instance.
+	_, err3 := subscribe(nc, c.subjects.Health, "", c.healthMessageHandler)
+	if err3 != nil {
+		return errors.New("Failed to subscribe to " + c.subjects.Health + " topic: " + err3.Error())
+	}
+
+	if c.cfg.JetStream.Enabled {
+		err2 := c.setupJetStream()
+		if err2 != nil {
+			return errors.New("Failed to set up JetStream: " + err2.Error())
+		}
+	}
+
+	if c.cfg.Dispatcher.Subject != "" {
+		log.Println("Tasks will be requested from dispatcher at", c.cfg.Dispatcher.Subject)
+	}
+
+	c.handlersMutex.Lock()
+	defer c.handlersMutex.Unlock()
+
+	c.listening = true
+	for _, hndl := range c.handlers {
+		err1 := hndl.start(c)
+		if err1 != nil {
+			return err1
+		}
 	}
-	natsSubscription = sub
-	log.Println("Subscribed to topic", Topic)
 
 	return nil
 }
+
+// Subjects returns subjects derived from configured prefix.
+func (c *Client) Subjects() *Subjects {
+	return c.subjects
+}
+
+// Subscribes to topic, within queue group if it isn't empty. Without
+// queue group every subscriber receives every message.
+func subscribe(nc *nats.Conn, topic string, group string, handler nats.MsgHandler) (*nats.Subscription, error) {
+	if group == "" {
+		sub, err := nc.Subscribe(topic, handler)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Println("Subscribed to topic", topic)
+		return sub, nil
+	}
+
+	sub, err := nc.QueueSubscribe(topic, group, handler)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("Subscribed to topic", topic, "within queue group", group)
+	return sub, nil
+}
+
+// Returns current connection, nil if client isn't connected.
+func (c *Client) connection() *nats.Conn {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+
+	return c.conn
+}
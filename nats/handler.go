@@ -1,6 +1,225 @@
 package nats
 
+import (
+	// stdlib
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	// local
+	"github.com/pztrn/ffmpeger/config"
+
+	// other
+	"github.com/nats-io/nats.go"
+)
+
+// Handler represents typed handler for messages received on one of
+// subjects. Message payload (which might be wrapped in envelope) is
+// decoded into value returned by New and passed to Func. If message was
+// sent as request then value returned by Func (or composed by
+// ErrorReply if Func failed) is sent back as reply, wrapped into
+// envelope if request had it.
 type Handler struct {
 	Name string
-	Func func(data []byte)
+	// Subject which messages should be passed to handler, see
+	// Subject* constants. Empty means SubjectSubmit. Any other name
+	// is appended to subject prefix, e.g. "thumbnails" handler will
+	// receive messages from "<prefix>.thumbnails".
+	Subject string
+	// Queue group for handler's subscription. Handlers of SubjectSubmit
+	// and SubjectProbe use configured queue group if it's empty.
+	QueueGroup string
+	// Returns pointer to value which message in passed schema version
+	// should be decoded into, or nil if version isn't supported.
+	New func(version int) interface{}
+	// Handles decoded message. Might return nil reply if it has nothing
+	// to reply.
+	Func func(msg interface{}) (interface{}, error)
+	// Composes reply for error. If nil - ErrorReply structure is sent.
+	ErrorReply func(err error) interface{}
+	// How many messages might be handled at once, each in it's own
+	// goroutine. Zero or one means that messages are handled one by one
+	// in order they were received.
+	Concurrency int
+
+	// Handler's subscription, nil if handler isn't subscribed.
+	subscription *nats.Subscription
+}
+
+// ErrorReply is a default reply for failed messages.
+type ErrorReply struct {
+	Error string
+}
+
+// Decode decodes message which might be wrapped in envelope into value
+// returned by newMessage for message's schema version. Unknown fields
+// aren't allowed. Also returns schema version and flag which indicates
+// that message had envelope.
+func Decode(data []byte, newMessage func(version int) interface{}) (interface{}, int, bool, error) {
+	version, payload, enveloped, err := Unwrap(data)
+	if err != nil {
+		return nil, 0, enveloped, err
+	}
+
+	msg := newMessage(version)
+	if msg == nil {
+		return nil, version, enveloped, errors.New("unsupported schema version " + strconv.Itoa(version))
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.DisallowUnknownFields()
+	err1 := decoder.Decode(msg)
+	if err1 != nil {
+		return nil, version, enveloped, errors.New("malformed message: " + err1.Error())
+	}
+
+	return msg, version, enveloped, nil
+}
+
+// Handle decodes message, passes it to handler and returns encoded
+// reply. Returns nil if there is nothing to reply. Handler's panic is
+// recovered and turned into error reply.
+func (h *Handler) Handle(data []byte) []byte {
+	msg, version, enveloped, err := Decode(data, h.New)
+
+	var reply interface{}
+	if err == nil {
+		reply, err = h.call(msg)
+	}
+
+	if err != nil {
+		log.Println("ERROR: handler", h.Name, "failed:", err.Error())
+		reply = h.errorReply(err)
+	}
+
+	if reply == nil {
+		return nil
+	}
+
+	replyData, err1 := json.Marshal(reply)
+	if err1 != nil {
+		log.Println("ERROR: failed to encode reply of handler", h.Name+":", err1.Error())
+		return nil
+	}
+
+	if !enveloped {
+		return replyData
+	}
+
+	wrapped, err2 := Wrap(version, replyData)
+	if err2 != nil {
+		log.Println("ERROR: failed to wrap reply of handler", h.Name, "into envelope:", err2.Error())
+		return nil
+	}
+
+	return wrapped
+}
+
+// Calls handler's Func, recovering from panic.
+func (h *Handler) call(msg interface{}) (reply interface{}, err error) {
+	defer func() {
+		rec := recover()
+		if rec != nil {
+			reply = nil
+			err = fmt.Errorf("handler panicked: %v", rec)
+		}
+	}()
+
+	return h.Func(msg)
+}
+
+// Composes reply for error.
+func (h *Handler) errorReply(err error) interface{} {
+	if h.ErrorReply == nil {
+		return &ErrorReply{Error: err.Error()}
+	}
+
+	return h.ErrorReply(err)
+}
+
+// Returns subject identifier which messages should be passed to
+// handler.
+func (h *Handler) subject() string {
+	if h.Subject == "" {
+		return SubjectSubmit
+	}
+
+	return h.Subject
+}
+
+// Returns queue group for handler's subscription.
+func (h *Handler) queueGroup(cfg *config.Nats) string {
+	if h.QueueGroup == "" && (h.subject() == SubjectSubmit || h.subject() == SubjectProbe) {
+		return cfg.QueueGroup
+	}
+
+	return h.QueueGroup
+}
+
+// Subscribes handler to it's subject. Should be called with client's
+// handlersMutex locked.
+func (h *Handler) start(c *Client) error {
+	subject := c.subjects.byName(h.subject())
+	sub, err := subscribe(c.connection(), subject, h.queueGroup(c.cfg), h.callback(c))
+	if err != nil {
+		return errors.New("Failed to subscribe handler " + h.Name + " to " + subject + " topic: " + err.Error())
+	}
+	h.subscription = sub
+
+	return nil
+}
+
+// Unsubscribes handler from it's subject. Should be called with
+// client's handlersMutex locked.
+func (h *Handler) stop() error {
+	if h.subscription == nil {
+		return nil
+	}
+
+	err := h.subscription.Unsubscribe()
+	if err != nil {
+		return errors.New("Failed to unsubscribe handler " + h.Name + " from " + h.subscription.Subject + " topic: " + err.Error())
+	}
+	h.subscription = nil
+
+	return nil
+}
+
+// Returns callback for handler's subscription. If handler is concurrent
+// messages are dispatched in goroutines, callback blocks only when
+// there are too many of them.
+func (h *Handler) callback(c *Client) nats.MsgHandler {
+	if h.Concurrency <= 1 {
+		return func(msg *nats.Msg) {
+			h.dispatch(c, msg)
+		}
+	}
+
+	slots := make(chan struct{}, h.Concurrency)
+	return func(msg *nats.Msg) {
+		slots <- struct{}{}
+		go func() {
+			defer func() { <-slots }()
+			h.dispatch(c, msg)
+		}()
+	}
+}
+
+// Handles message received by handler's subscription. Every handler has
+// it's own subscription, so handlers don't block each other.
+func (h *Handler) dispatch(c *Client, msg *nats.Msg) {
+	log.Println("Received message on", msg.Subject+":", string(msg.Data))
+
+	reply := h.Handle(msg.Data)
+	if msg.Reply == "" || reply == nil {
+		return
+	}
+
+	err := c.Publish(msg.Reply, reply)
+	if err != nil {
+		log.Println("ERROR: failed to send reply:", err.Error())
+	}
 }
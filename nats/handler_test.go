@@ -0,0 +1,104 @@
+package nats
+
+import (
+	// stdlib
+	"errors"
+	"testing"
+	"time"
+
+	// other
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+)
+
+type testReply struct {
+	Echo  string
+	Error string
+}
+
+func TestHandlerHandle(t *testing.T) {
+	hndl := &Handler{
+		Name: "testhandler",
+		New:  newTestMessage,
+		Func: func(msg interface{}) (interface{}, error) {
+			text := msg.(*testMessage).Text
+			switch text {
+			case "fail":
+				return nil, errors.New("failed")
+			case "panic":
+				panic("oops")
+			case "silent":
+				return nil, nil
+			}
+
+			return &testReply{Echo: text}, nil
+		},
+		ErrorReply: func(err error) interface{} {
+			return &testReply{Error: err.Error()}
+		},
+	}
+
+	tests := []struct {
+		name    string
+		message string
+		reply   string
+	}{
+		{"reply", `{"Text": "hello"}`, `{"Echo":"hello","Error":""}`},
+		{"no reply", `{"Text": "silent"}`, ``},
+		{"error", `{"Text": "fail"}`, `{"Echo":"","Error":"failed"}`},
+		{"panic", `{"Text": "panic"}`, `{"Echo":"","Error":"handler panicked: oops"}`},
+		{"malformed", `{"Message": "hello"}`, `{"Echo":"","Error":"malformed message: json: unknown field \"Message\""}`},
+		{"enveloped", `{"Version": 1, "Payload": {"Text": "hello"}}`, `{"Version":1,"Payload":{"Echo":"hello","Error":""}}`},
+		{"unsupported version", `{"Version": 2, "Payload": {"Text": "hello"}}`, `{"Version":2,"Payload":{"Echo":"","Error":"unsupported schema version 2"}}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.reply, string(hndl.Handle([]byte(test.message))))
+		})
+	}
+}
+
+func TestHandlerDefaultErrorReply(t *testing.T) {
+	hndl := &Handler{
+		Name: "testhandler",
+		New:  newTestMessage,
+		Func: func(msg interface{}) (interface{}, error) {
+			return nil, errors.New("failed")
+		},
+	}
+
+	require.Equal(t, `{"Error":"failed"}`, string(hndl.Handle([]byte(`{"Text": "hello"}`))))
+}
+
+func TestHandlerConcurrency(t *testing.T) {
+	started := make(chan string, 2)
+	release := make(chan struct{})
+	hndl := &Handler{
+		Name: "testhandler",
+		New:  newTestMessage,
+		Func: func(msg interface{}) (interface{}, error) {
+			started <- msg.(*testMessage).Text
+			<-release
+			return nil, nil
+		},
+		Concurrency: 2,
+	}
+	defer close(release)
+
+	// Second message is handled while first one is still handled.
+	callback := hndl.callback(nil)
+	callback(&nats.Msg{Data: []byte(`{"Text": "first"}`)})
+	callback(&nats.Msg{Data: []byte(`{"Text": "second"}`)})
+
+	received := make([]string, 0, 2)
+	for i := 0; i < 2; i++ {
+		select {
+		case text := <-started:
+			received = append(received, text)
+		case <-time.After(time.Second):
+			t.Fatal("Message wasn't handled in 1 second")
+		}
+	}
+	require.ElementsMatch(t, []string{"first", "second"}, received)
+}
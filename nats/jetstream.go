@@ -0,0 +1,307 @@
+package nats
+
+import (
+	// stdlib
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	// other
+	"github.com/nats-io/nats.go"
+)
+
+// Vendored NATS client doesn't know about JetStream, so we're talking to
+// JetStream API directly. See
+// https://docs.nats.io/reference/reference-protocols/nats_api_reference
+const (
+	jsAPIPrefix = "$JS.API"
+	// How long to wait for JetStream API reply.
+	jsAPITimeout = time.Second * 5
+	// Error code for nonexistent stream or consumer.
+	jsNotFound = 404
+)
+
+// JetStream API error.
+type jsAPIError struct {
+	Code        int    `json:"code"`
+	Description string `json:"description"`
+}
+
+func (e *jsAPIError) Error() string {
+	return strconv.Itoa(e.Code) + " " + e.Description
+}
+
+// Common part of all JetStream API replies.
+type jsAPIResponse struct {
+	Error *jsAPIError `json:"error"`
+}
+
+type jsStreamConfig struct {
+	Name      string   `json:"name"`
+	Subjects  []string `json:"subjects"`
+	Retention string   `json:"retention"`
+	Storage   string   `json:"storage"`
+}
+
+type jsConsumerConfig struct {
+	DurableName   string `json:"durable_name"`
+	DeliverPolicy string `json:"deliver_policy"`
+	AckPolicy     string `json:"ack_policy"`
+	AckWait       int64  `json:"ack_wait"`
+	MaxDeliver    int    `json:"max_deliver"`
+	FilterSubject string `json:"filter_subject"`
+}
+
+type jsConsumerCreateRequest struct {
+	Stream string           `json:"stream_name"`
+	Config jsConsumerConfig `json:"config"`
+}
+
+type jsNextRequest struct {
+	Batch   int   `json:"batch"`
+	Expires int64 `json:"expires"`
+}
+
+// PubAck is a reply which JetStream sends for message published to
+// stream's subject as request.
+type PubAck struct {
+	Stream   string      `json:"stream"`
+	Sequence uint64      `json:"seq"`
+	Error    *jsAPIError `json:"error"`
+}
+
+// JetStreamMessage is a message fetched from JetStream consumer. Every
+// message should be acknowledged, otherwise it'll be redelivered after
+// ack wait.
+type JetStreamMessage struct {
+	Data []byte
+	// Client which fetched message.
+	client *Client
+	// Subject for acknowledgements.
+	reply string
+	// Stream sequence.
+	sequence uint64
+	// How many times message was delivered, starting from 1.
+	deliveries int
+}
+
+// Ack acknowledges successful processing, message will be removed
+// from stream.
+func (m *JetStreamMessage) Ack() error {
+	return m.client.Publish(m.reply, []byte("+ACK"))
+}
+
+// Deliveries returns how many times message was delivered, including
+// this delivery.
+func (m *JetStreamMessage) Deliveries() int {
+	return m.deliveries
+}
+
+// InProgress tells JetStream that message is still being processed,
+// so ack wait starts over.
+func (m *JetStreamMessage) InProgress() error {
+	return m.client.Publish(m.reply, []byte("+WPI"))
+}
+
+// Nak tells JetStream that processing failed. Message will be
+// redelivered after delay.
+func (m *JetStreamMessage) Nak(delay time.Duration) error {
+	return m.client.Publish(m.reply, nakPayload(delay))
+}
+
+// Sequence returns message's sequence in stream. It's unique and stays
+// same across redeliveries.
+func (m *JetStreamMessage) Sequence() uint64 {
+	return m.sequence
+}
+
+// Term tells JetStream that message can't be processed ever, so it
+// shouldn't be redelivered.
+func (m *JetStreamMessage) Term() error {
+	return m.client.Publish(m.reply, []byte("+TERM"))
+}
+
+// Composes negative acknowledgement.
+func nakPayload(delay time.Duration) []byte {
+	if delay <= 0 {
+		return []byte("-NAK")
+	}
+
+	return []byte(`-NAK {"delay": ` + strconv.FormatInt(delay.Nanoseconds(), 10) + `}`)
+}
+
+// Parses acknowledgement subject, which looks like
+// "$JS.ACK.<stream>.<consumer>.<delivered>.<stream seq>.<consumer seq>.<timestamp>.<pending>"
+// and might have domain and account hash after "$JS.ACK" on newer
+// servers.
+func parseAckSubject(subject string) (*JetStreamMessage, error) {
+	tokens := strings.Split(subject, ".")
+	if len(tokens) < 9 || tokens[0] != "$JS" || tokens[1] != "ACK" {
+		return nil, errors.New("not a JetStream acknowledgement subject: " + subject)
+	}
+
+	offset := 4
+	if len(tokens) >= 12 {
+		offset = 6
+	}
+
+	deliveries, err := strconv.Atoi(tokens[offset])
+	if err != nil {
+		return nil, errors.New("invalid deliveries count in " + subject)
+	}
+
+	sequence, err1 := strconv.ParseUint(tokens[offset+1], 10, 64)
+	if err1 != nil {
+		return nil, errors.New("invalid stream sequence in " + subject)
+	}
+
+	return &JetStreamMessage{
+		reply:      subject,
+		sequence:   sequence,
+		deliveries: deliveries,
+	}, nil
+}
+
+// Sends request to JetStream API and decodes reply into resp, which
+// should embed jsAPIResponse.
+func (c *Client) jsRequest(subject string, req interface{}, resp interface{}) error {
+	var data []byte
+	if req != nil {
+		encoded, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		data = encoded
+	}
+
+	nc := c.connection()
+	if nc == nil {
+		return errors.New("Not connected to NATS")
+	}
+
+	msg, err1 := nc.Request(jsAPIPrefix+"."+subject, data, jsAPITimeout)
+	if err1 != nil {
+		return err1
+	}
+
+	return json.Unmarshal(msg.Data, resp)
+}
+
+// Creates stream and durable consumer for tasks if they don't exist.
+func (c *Client) setupJetStream() error {
+	cfg := &c.cfg.JetStream
+
+	info := &jsAPIResponse{}
+	err := c.jsRequest("STREAM.INFO."+cfg.Stream, nil, info)
+	if err != nil {
+		return errors.New("Failed to get stream info: " + err.Error())
+	}
+
+	if info.Error != nil && info.Error.Code == jsNotFound {
+		log.Println("Creating JetStream stream", cfg.Stream+"...")
+
+		created := &jsAPIResponse{}
+		err1 := c.jsRequest("STREAM.CREATE."+cfg.Stream, &jsStreamConfig{
+			Name:      cfg.Stream,
+			Subjects:  []string{c.subjects.Submit},
+			Retention: "workqueue",
+			Storage:   "file",
+		}, created)
+		if err1 != nil {
+			return errors.New("Failed to create stream: " + err1.Error())
+		}
+		info = created
+	}
+
+	if info.Error != nil {
+		return errors.New("Failed to set up stream: " + info.Error.Error())
+	}
+
+	consumer := &jsAPIResponse{}
+	err2 := c.jsRequest("CONSUMER.DURABLE.CREATE."+cfg.Stream+"."+cfg.Consumer, &jsConsumerCreateRequest{
+		Stream: cfg.Stream,
+		Config: jsConsumerConfig{
+			DurableName:   cfg.Consumer,
+			DeliverPolicy: "all",
+			AckPolicy:     "explicit",
+			AckWait:       cfg.AckWait.Nanoseconds(),
+			MaxDeliver:    cfg.MaxDeliver,
+			FilterSubject: c.subjects.Submit,
+		},
+	}, consumer)
+	if err2 != nil {
+		return errors.New("Failed to create consumer: " + err2.Error())
+	}
+
+	if consumer.Error != nil {
+		return errors.New("Failed to create consumer: " + consumer.Error.Error())
+	}
+
+	log.Println("Using JetStream consumer", cfg.Consumer, "of stream", cfg.Stream)
+
+	return nil
+}
+
+// Fetch fetches up to batch tasks from JetStream consumer, waiting for
+// them no longer than wait.
+func (c *Client) Fetch(batch int, wait time.Duration) ([]*JetStreamMessage, error) {
+	nc := c.connection()
+	if nc == nil {
+		return nil, errors.New("Not connected to NATS")
+	}
+
+	cfg := &c.cfg.JetStream
+
+	inbox := nats.NewInbox()
+	sub, err := nc.SubscribeSync(inbox)
+	if err != nil {
+		return nil, errors.New("Failed to subscribe to inbox: " + err.Error())
+	}
+	defer sub.Unsubscribe()
+
+	data, err1 := json.Marshal(&jsNextRequest{
+		Batch:   batch,
+		Expires: wait.Nanoseconds(),
+	})
+	if err1 != nil {
+		return nil, errors.New("Failed to encode fetch request: " + err1.Error())
+	}
+
+	err2 := nc.PublishRequest(jsAPIPrefix+".CONSUMER.MSG.NEXT."+cfg.Stream+"."+cfg.Consumer, inbox, data)
+	if err2 != nil {
+		return nil, errors.New("Failed to request tasks: " + err2.Error())
+	}
+
+	// Wait a bit longer than request expiration, so messages sent right
+	// before it won't be lost.
+	deadline := time.Now().Add(wait + time.Millisecond*100)
+	messages := make([]*JetStreamMessage, 0, batch)
+	for len(messages) < batch {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+
+		msg, err3 := sub.NextMsg(remaining)
+		if err3 == nats.ErrTimeout {
+			break
+		}
+		if err3 != nil {
+			return messages, errors.New("Failed to receive tasks: " + err3.Error())
+		}
+
+		// Status messages have no acknowledgement subject.
+		jsMsg, err4 := parseAckSubject(msg.Reply)
+		if err4 != nil {
+			continue
+		}
+		jsMsg.Data = msg.Data
+		jsMsg.client = c
+		messages = append(messages, jsMsg)
+	}
+
+	return messages, nil
+}
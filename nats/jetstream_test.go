@@ -0,0 +1,42 @@
+package nats
+
+import (
+	// stdlib
+	"testing"
+	"time"
+
+	// other
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAckSubject(t *testing.T) {
+	tests := []struct {
+		subject    string
+		deliveries int
+		sequence   uint64
+		valid      bool
+	}{
+		{"$JS.ACK.FFMPEGER.ffmpeger.1.42.40.1700000000000000000.3", 1, 42, true},
+		{"$JS.ACK.hub.ACCHASH.FFMPEGER.ffmpeger.3.7.5.1700000000000000000.0.token", 3, 7, true},
+		{"_INBOX.abcdef", 0, 0, false},
+		{"$JS.ACK.FFMPEGER.ffmpeger.x.42.40.1700000000000000000.3", 0, 0, false},
+		{"", 0, 0, false},
+	}
+
+	for _, test := range tests {
+		msg, err := parseAckSubject(test.subject)
+		if !test.valid {
+			require.NotNil(t, err, test.subject)
+			continue
+		}
+
+		require.Nil(t, err, test.subject)
+		require.Equal(t, test.deliveries, msg.Deliveries(), test.subject)
+		require.Equal(t, test.sequence, msg.Sequence(), test.subject)
+	}
+}
+
+func TestNakPayload(t *testing.T) {
+	require.Equal(t, "-NAK", string(nakPayload(0)))
+	require.Equal(t, `-NAK {"delay": 30000000000}`, string(nakPayload(time.Second*30)))
+}
@@ -2,8 +2,8 @@ package nats
 
 import (
 	// stdlib
-	"flag"
 	"testing"
+	"time"
 
 	// local
 	"github.com/pztrn/ffmpeger/config"
@@ -13,100 +13,240 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-func TestNATSInitialization(t *testing.T) {
-	Initialize()
-	require.NotNil(t, handlers)
-	require.Empty(t, handlers)
+type testMessage struct {
+	Text string
 }
 
-func TestNATSStartListeningAndShutdown(t *testing.T) {
-	flag.CommandLine = flag.NewFlagSet("ffmpeger-test-nats", flag.ExitOnError)
+func newTestMessage(version int) interface{} {
+	if version != SchemaVersion1 {
+		return nil
+	}
+
+	return &testMessage{}
+}
 
-	Initialize()
-	require.NotNil(t, handlers)
-	require.Empty(t, handlers)
+// Creates client for passed configuration and fails test if
+// configuration is invalid.
+func newTestClient(t *testing.T, cfg *config.Nats) *Client {
+	c, err := NewClient(cfg)
+	require.Nil(t, err)
+
+	return c
+}
 
-	config.Initialize()
-	config.Cfg.NATS.ConnectionString = "nats://127.0.0.1:14222"
+func TestNATSNewClient(t *testing.T) {
+	c := newTestClient(t, &config.Nats{})
+	require.Empty(t, c.handlers)
+	// Defaults are filled for configuration composed in code.
+	require.Equal(t, "ffmpeger.v1", c.cfg.SubjectPrefix)
+	require.Equal(t, time.Minute, c.cfg.JetStream.AckWait)
 
-	err := StartListening()
+	_, err := NewClient(&config.Nats{ReconnectWait: -time.Second})
+	require.NotNil(t, err)
+}
+
+func TestNATSStartListeningAndShutdown(t *testing.T) {
+	c := newTestClient(t, &config.Nats{ConnectionString: "nats://127.0.0.1:14222"})
+	require.Empty(t, c.handlers)
+
+	err := c.StartListening()
 	require.Nil(t, err)
 
-	err1 := Shutdown()
+	err1 := c.Shutdown()
 	require.Nil(t, err1)
 }
 
 func TestNATSShutdownWithoutConnection(t *testing.T) {
-	Initialize()
-	require.NotNil(t, handlers)
-	require.Empty(t, handlers)
+	c := newTestClient(t, &config.Nats{})
+	require.Empty(t, c.handlers)
 
-	err := Shutdown()
+	err := c.Shutdown()
 	require.NotNil(t, err)
 }
 
 func TestNATSConnectToWrongAddress(t *testing.T) {
-	flag.CommandLine = flag.NewFlagSet("ffmpeger-test-nats", flag.ExitOnError)
-	Initialize()
-	require.NotNil(t, handlers)
-	require.Empty(t, handlers)
-
-	config.Initialize()
-	config.Cfg.NATS.ConnectionString = "nats://127.0.0.1:14223"
+	c := newTestClient(t, &config.Nats{ConnectionString: "nats://127.0.0.1:14223"})
+	require.Empty(t, c.handlers)
 
-	err := StartListening()
+	err := c.StartListening()
 	require.NotNil(t, err)
 }
 
 func TestNATSAddHandler(t *testing.T) {
-	d := func(data []byte) {}
+	d := func(msg interface{}) (interface{}, error) { return nil, nil }
 
-	Initialize()
-	require.NotNil(t, handlers)
-	require.Empty(t, handlers)
+	c := newTestClient(t, &config.Nats{})
+	require.Empty(t, c.handlers)
 
 	hndl := &Handler{
 		Name: "testhandler",
+		New:  newTestMessage,
 		Func: d,
 	}
-	AddHandler(hndl)
-}
+	require.Nil(t, c.AddHandler(hndl))
 
-func TestNATSReceiveMessage(t *testing.T) {
-	flag.CommandLine = flag.NewFlagSet("ffmpeger-test-nats", flag.ExitOnError)
+	// Only one handler per subject.
+	require.NotNil(t, c.AddHandler(&Handler{Name: "another", New: newTestMessage, Func: d}))
+	require.Nil(t, c.AddHandler(&Handler{Name: "control", Subject: SubjectControl, New: newTestMessage, Func: d}))
 
-	Initialize()
-	require.NotNil(t, handlers)
-	require.Empty(t, handlers)
+	// Handler without decoder.
+	require.NotNil(t, c.AddHandler(&Handler{Name: "broken", Subject: "other", Func: d}))
+}
 
-	config.Initialize()
-	config.Cfg.NATS.ConnectionString = "nats://127.0.0.1:14222"
+func TestNATSReceiveMessage(t *testing.T) {
+	c := newTestClient(t, &config.Nats{ConnectionString: "nats://127.0.0.1:14222"})
+	require.Empty(t, c.handlers)
 
-	err := StartListening()
+	err := c.StartListening()
 	require.Nil(t, err)
 
 	received := make(chan bool, 1)
-	d := func(data []byte) {
-		t.Log("Received data:", data)
+	d := func(msg interface{}) (interface{}, error) {
+		t.Log("Received message:", msg.(*testMessage).Text)
 		received <- true
+		return nil, nil
 	}
 
 	hndl := &Handler{
 		Name: "testhandler",
+		New:  newTestMessage,
 		Func: d,
 	}
-	AddHandler(hndl)
+	require.Nil(t, c.AddHandler(hndl))
 
 	// Send message.
-	nc, err1 := nats.Connect(config.Cfg.NATS.ConnectionString)
+	nc, err1 := nats.Connect(c.cfg.ConnectionString)
 	require.Nil(t, err1)
 
-	err2 := nc.Publish(Topic, []byte("Hello, world!"))
+	err2 := nc.Publish(c.Subjects().Submit, []byte(`{"Text": "Hello, world!"}`))
 	require.Nil(t, err2)
 
 	<-received
 	nc.Close()
 
-	err3 := Shutdown()
+	err3 := c.Shutdown()
+	require.Nil(t, err3)
+}
+
+func TestNATSQueueGroupDeliversTaskOnce(t *testing.T) {
+	c := newTestClient(t, &config.Nats{
+		ConnectionString: "nats://127.0.0.1:14222",
+		QueueGroup:       "ffmpeger-test",
+	})
+
+	err := c.StartListening()
+	require.Nil(t, err)
+
+	const messagesCount = 30
+	received := make(chan string, messagesCount*3)
+	require.Nil(t, c.AddHandler(&Handler{
+		Name: "testhandler",
+		New:  newTestMessage,
+		Func: func(msg interface{}) (interface{}, error) {
+			received <- "listener"
+			return nil, nil
+		},
+	}))
+
+	// Two more "instances" within same queue group and one outside it
+	// which should receive every message.
+	var subscribers []*nats.Conn
+	for _, name := range []string{"first", "second"} {
+		nc, err1 := nats.Connect(c.cfg.ConnectionString)
+		require.Nil(t, err1)
+		subscribers = append(subscribers, nc)
+
+		subscriberName := name
+		_, err2 := subscribe(nc, c.Subjects().Submit, c.cfg.QueueGroup, func(msg *nats.Msg) {
+			received <- subscriberName
+		})
+		require.Nil(t, err2)
+		require.Nil(t, nc.Flush())
+	}
+
+	observer, err3 := nats.Connect(c.cfg.ConnectionString)
 	require.Nil(t, err3)
+	observed := make(chan bool, messagesCount)
+	_, err4 := subscribe(observer, c.Subjects().Submit, "", func(msg *nats.Msg) {
+		observed <- true
+	})
+	require.Nil(t, err4)
+	require.Nil(t, observer.Flush())
+
+	nc, err5 := nats.Connect(c.cfg.ConnectionString)
+	require.Nil(t, err5)
+	for i := 0; i < messagesCount; i++ {
+		require.Nil(t, nc.Publish(c.Subjects().Submit, []byte(`{"Text": "Hello, world!"}`)))
+	}
+	require.Nil(t, nc.Flush())
+
+	for i := 0; i < messagesCount; i++ {
+		<-received
+		<-observed
+	}
+
+	// Nothing should be received twice.
+	time.Sleep(time.Millisecond * 100)
+	require.Len(t, received, 0)
+
+	nc.Close()
+	observer.Close()
+	for _, subscriber := range subscribers {
+		subscriber.Close()
+	}
+
+	err6 := c.Shutdown()
+	require.Nil(t, err6)
+}
+
+func TestNATSRemoveHandler(t *testing.T) {
+	d := func(msg interface{}) (interface{}, error) { return nil, nil }
+
+	c := newTestClient(t, &config.Nats{})
+	require.Nil(t, c.AddHandler(&Handler{Name: "converter", New: newTestMessage, Func: d}))
+	require.Nil(t, c.AddHandler(&Handler{Name: "thumbnailer", Subject: "thumbnails", QueueGroup: "thumbnailers", New: newTestMessage, Func: d}))
+	require.Len(t, c.handlers, 2)
+
+	// Reserved and malformed subjects.
+	require.NotNil(t, c.AddHandler(&Handler{Name: "results", Subject: "results", New: newTestMessage, Func: d}))
+	require.NotNil(t, c.AddHandler(&Handler{Name: "wildcard", Subject: "thumbnails.*", New: newTestMessage, Func: d}))
+
+	require.Nil(t, c.RemoveHandler("thumbnailer"))
+	require.Len(t, c.handlers, 1)
+	require.NotNil(t, c.RemoveHandler("thumbnailer"))
+
+	// Subject is free again.
+	require.Nil(t, c.AddHandler(&Handler{Name: "thumbnailer-v2", Subject: "thumbnails", New: newTestMessage, Func: d}))
+}
+
+func TestNATSHandlerLifecycle(t *testing.T) {
+	c := newTestClient(t, &config.Nats{ConnectionString: "nats://127.0.0.1:14222"})
+
+	err := c.StartListening()
+	require.Nil(t, err)
+
+	// Handler added while listening is subscribed immediately.
+	require.Nil(t, c.AddHandler(&Handler{
+		Name:    "thumbnailer",
+		Subject: "thumbnails",
+		New:     newTestMessage,
+		Func: func(msg interface{}) (interface{}, error) {
+			return msg, nil
+		},
+	}))
+
+	nc, err1 := nats.Connect(c.cfg.ConnectionString)
+	require.Nil(t, err1)
+	defer nc.Close()
+
+	reply, err2 := nc.Request(c.Subjects().Submit+".thumbnails", []byte(`{"Text": "Hello, world!"}`), time.Second)
+	require.Nil(t, err2)
+	require.Equal(t, `{"Text":"Hello, world!"}`, string(reply.Data))
+
+	require.Nil(t, c.RemoveHandler("thumbnailer"))
+	_, err3 := nc.Request(c.Subjects().Submit+".thumbnails", []byte(`{"Text": "Hello, world!"}`), time.Millisecond*100)
+	require.NotNil(t, err3)
+
+	err4 := c.Shutdown()
+	require.Nil(t, err4)
 }
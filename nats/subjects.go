@@ -0,0 +1,99 @@
+package nats
+
+import (
+	// stdlib
+	"errors"
+	"strings"
+)
+
+// DefaultSubjectPrefix is used if subject prefix isn't configured. It
+// produces same subjects which were used before prefix became
+// configurable.
+const DefaultSubjectPrefix = "ffmpeger.v1"
+
+const (
+	// SubjectSubmit identifies subject where tasks are submitted.
+	SubjectSubmit = "submit"
+	// SubjectControl identifies subject where control commands (like
+	// tasks cancellation) are received.
+	SubjectControl = "control"
+	// SubjectProbe identifies subject where media inspection requests
+	// are received. It isn't captured by JetStream stream, so requests
+	// are always replied.
+	SubjectProbe = "probe"
+)
+
+// Subjects represents subjects derived from subject prefix. Prefix
+// allows several ffmpeger deployments (e.g. staging and production) to
+// share one NATS cluster.
+type Subjects struct {
+	// Tasks are received here. It's a prefix itself.
+	Submit string
+	// Control commands are received here.
+	Control string
+	// Media inspection requests are received here.
+	Probe string
+	// Tasks results are published here.
+	Results string
+	// Health requests are received here.
+	Health string
+	// Tasks that failed for good are published here.
+	DeadLetter string
+
+	// Prefix for tasks progress subjects.
+	progressPrefix string
+}
+
+// NewSubjects returns subjects derived from passed prefix. Empty prefix
+// means DefaultSubjectPrefix.
+func NewSubjects(prefix string) *Subjects {
+	if prefix == "" {
+		prefix = DefaultSubjectPrefix
+	}
+
+	return &Subjects{
+		Submit:         prefix,
+		Control:        prefix + ".control",
+		Probe:          prefix + ".probe",
+		Results:        prefix + ".results",
+		Health:         prefix + ".health",
+		DeadLetter:     prefix + ".deadletter",
+		progressPrefix: prefix + ".progress",
+	}
+}
+
+// Progress returns subject where progress of task with passed ID is
+// published.
+func (s *Subjects) Progress(taskID string) string {
+	return s.progressPrefix + "." + taskID
+}
+
+// Returns subject for passed subject identifier (see Subject*
+// constants). Other identifiers are appended to prefix.
+func (s *Subjects) byName(name string) string {
+	switch name {
+	case SubjectSubmit:
+		return s.Submit
+	case SubjectControl:
+		return s.Control
+	case SubjectProbe:
+		return s.Probe
+	}
+
+	return s.Submit + "." + name
+}
+
+// Checks that handler can be bound to subject with passed identifier.
+// Subjects where we publish something can't be used.
+func validateSubjectName(name string) error {
+	switch name {
+	case "results", "health", "progress", "deadletter":
+		return errors.New("subject " + name + " is reserved")
+	}
+
+	if name == "" || strings.ContainsAny(name, ".*> ") {
+		return errors.New("subject identifier should be a single token without wildcards")
+	}
+
+	return nil
+}